@@ -0,0 +1,365 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ArcExt 複数の.fixedファイルをまとめたアーカイブの拡張子
+const ArcExt = ".arc"
+
+// アーカイブのヘッダのメタデータのキー
+const (
+	MetaArcPeriod = "arc_period" // アーカイブの期間 (time.Duration)
+	MetaArcInputs = "arc_inputs" // まとめた.fixedファイル名 (カンマ区切り)
+)
+
+func ArcGlob(db *DB) ([]DBFiles, error) {
+	return dbGlob(db, ArcExt)
+}
+
+// Archive アーカイブファイル
+// ファイル全体が1つのzlibストリームで、中身はFtailDBのバイナリ形式の行(Textは展開済み)
+type Archive struct {
+	Path   string
+	Pos    *Position // ヘッダのPosition
+	Meta   map[string]string
+	Period time.Duration
+
+	file    *os.File
+	zr      io.ReadCloser
+	r       *bufio.Reader
+	version int // ヘッダのMetaVersion
+}
+
+// OpenArchive アーカイブを開いてヘッダを読み込む
+func OpenArchive(path string) (*Archive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &Archive{Path: path, file: f}
+	if a.zr, err = zlib.NewReader(bufio.NewReader(f)); err != nil {
+		f.Close()
+		return nil, &InvalidFtailDBError{File: path, S: err.Error()}
+	}
+	a.r = bufio.NewReader(a.zr)
+	header, err := decodeRow(a.r)
+	if err != nil {
+		a.Close()
+		return nil, &InvalidFtailDBError{File: path, S: err.Error()}
+	}
+	a.Pos = header.Pos
+	if header.Text != "" {
+		if err := json.Unmarshal([]byte(header.Text), &a.Meta); err != nil {
+			a.Close()
+			return nil, &InvalidFtailDBError{File: path, S: err.Error()}
+		}
+	}
+	if a.version, err = formatVersion(a.Meta); err != nil {
+		a.Close()
+		return nil, &InvalidFtailDBError{File: path, S: err.Error()}
+	}
+	a.Period, _ = time.ParseDuration(a.Meta[MetaArcPeriod])
+	return a, nil
+}
+
+// ReadRows 全ての行を順番にfnに渡す
+func (a *Archive) ReadRows(fn func(row *Row) error) error {
+	for line := 1; ; line++ {
+		row, err := decodeRow(a.r)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return &InvalidFtailDBError{Line: line, File: a.Path, S: err.Error()}
+		}
+		if err := checkRecs(a.version, row); err != nil {
+			return &InvalidFtailDBError{Line: line, File: a.Path, S: err.Error()}
+		}
+		row.Info = nil // 展開後の位置なのでファイル上の位置ではない
+		if row.Pos.Name == "" {
+			row.Pos.Name = a.Pos.Name
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+}
+
+func (a *Archive) Close() error {
+	if a.zr != nil {
+		a.zr.Close()
+	}
+	return a.file.Close()
+}
+
+// Inputs アーカイブにまとめた.fixedファイル名
+func (a *Archive) Inputs() []string {
+	if a.Meta[MetaArcInputs] == "" {
+		return nil
+	}
+	return strings.Split(a.Meta[MetaArcInputs], ",")
+}
+
+// Truncate periodが1日の場合はtimezoneを考慮する
+func Truncate(t time.Time, d time.Duration) time.Time {
+	if d == 24*time.Hour {
+		y, m, day := t.Date()
+		return time.Date(y, m, day, 0, 0, 0, 0, t.Location())
+	}
+	return t.Truncate(d)
+}
+
+// windowEnd startから始まる期間の終わり
+func windowEnd(start time.Time, d time.Duration) time.Time {
+	if d == 24*time.Hour {
+		return start.AddDate(0, 0, 1)
+	}
+	return start.Add(d)
+}
+
+// Compact beforeより前に終わるperiod毎の期間の.fixedファイルをアーカイブにまとめる
+// まとめた後に検証してから元のファイルを削除する。作成したアーカイブのパスを返す
+func Compact(db *DB, period time.Duration, before time.Time) ([]string, error) {
+	if period <= 0 || period > 24*time.Hour {
+		return nil, fmt.Errorf("invalid compact period: %s", period)
+	}
+	fixed, err := FixGlob(db)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := RecGlob(db)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for len(fixed) > 0 {
+		start := Truncate(fixed[0].Time, period)
+		end := windowEnd(start, period)
+		var files []DBFiles
+		for len(fixed) > 0 && fixed[0].Time.Before(end) {
+			files = append(files, fixed[0])
+			fixed = fixed[1:]
+		}
+		if end.After(before) || hasFile(recs, start, end) {
+			continue // まだ書き込み中の期間
+		}
+		arc, err := compactWindow(db, start, period, files)
+		if err != nil {
+			return res, err
+		}
+		if arc != "" {
+			res = append(res, arc)
+		}
+	}
+	return res, nil
+}
+
+func hasFile(files []DBFiles, start, end time.Time) bool {
+	for _, f := range files {
+		if !f.Time.Before(start) && f.Time.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+func compactWindow(db *DB, start time.Time, period time.Duration, files []DBFiles) (string, error) {
+	d := &DB{Path: db.Path, Name: db.Name, Time: start}
+	arcPath := d.MakeFilefullPath(ArcExt)
+	if _, err := os.Stat(arcPath); err == nil {
+		// 前回の削除が途中で終わった場合はアーカイブに含まれているファイルだけ削除する
+		return "", removeArchived(db, arcPath, files)
+	}
+	var inputs []string
+	var header *Position
+	var meta map[string]string
+	for _, f := range files {
+		res, err := Verify(f.Path)
+		if err != nil {
+			return "", err
+		}
+		if !res.OK() {
+			db.logger().Warn("compact: skip broken file", "archive", arcPath, "file", f.Path, "errors", res.Errors)
+			return "", nil
+		}
+		if header == nil {
+			header, meta = res.Header, res.Meta
+		}
+		inputs = append(inputs, filepath.Base(f.Path))
+	}
+	m := make(map[string]string, len(meta)+2)
+	for k, v := range meta {
+		m[k] = v
+	}
+	m[MetaArcPeriod] = period.String()
+	m[MetaArcInputs] = strings.Join(inputs, ",")
+
+	tmp := arcPath + ".tmp"
+	rows, size, err := writeArchive(tmp, header, m, files)
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err = verifyArchive(tmp, rows, size); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err = os.Rename(tmp, arcPath); err != nil {
+		return "", err
+	}
+	db.logger().Info("compact", "files", len(files), "archive", arcPath)
+	return arcPath, removeArchived(db, arcPath, files)
+}
+
+// writeArchive filesの全ての行をアーカイブに書き込み、行数と展開後のサイズを返す
+func writeArchive(path string, header *Position, meta map[string]string, files []DBFiles) (int, int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+	zw, err := zlib.NewWriterLevel(bw, zlib.BestCompression)
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := json.Marshal(versionMeta(meta))
+	if err != nil {
+		return 0, 0, err
+	}
+	data, err := encodeRow(Row{Pos: header, Text: string(b)})
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err = zw.Write(data); err != nil {
+		return 0, 0, err
+	}
+	rows := 0
+	size := int64(0)
+	for _, in := range files {
+		fdb, err := FtailDBOpen(in.Path, 0644, &FtailDBOptions{ReadOnly: true, Bin: true}, nil)
+		if err != nil {
+			return 0, 0, err
+		}
+		err = fdb.ReadRows(func(row *Row) error {
+			if row.Bin != nil { // 行をまたいで圧縮するので展開して保存する
+				var b bytes.Buffer
+				if _, err := row.WriteTo(&b); err != nil {
+					return err
+				}
+				row.Text, row.Bin = b.String(), nil
+			}
+			data, err := encodeRow(*row)
+			if err != nil {
+				return err
+			}
+			rows++
+			size += int64(len(row.Text))
+			_, err = zw.Write(data)
+			return err
+		})
+		fdb.Close()
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return 0, 0, err
+	}
+	if err = bw.Flush(); err != nil {
+		return 0, 0, err
+	}
+	return rows, size, f.Sync()
+}
+
+func verifyArchive(path string, rows int, size int64) error {
+	a, err := OpenArchive(path)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	n := 0
+	sz := int64(0)
+	if err = a.ReadRows(func(row *Row) error {
+		n++
+		sz += int64(len(row.Text))
+		return nil
+	}); err != nil {
+		return err
+	}
+	if n != rows || sz != size {
+		return fmt.Errorf("compact: verify %s failed. rows:%d/%d size:%d/%d", path, n, rows, sz, size)
+	}
+	return nil
+}
+
+// removeArchived アーカイブに含まれているファイルを削除する
+func removeArchived(db *DB, arcPath string, files []DBFiles) error {
+	a, err := OpenArchive(arcPath)
+	if err != nil {
+		return err
+	}
+	inputs := map[string]bool{}
+	for _, in := range a.Inputs() {
+		inputs[in] = true
+	}
+	a.Close()
+	for _, f := range files {
+		if !inputs[filepath.Base(f.Path)] {
+			db.logger().Warn("compact: file is not in the archive", "archive", arcPath, "file", f.Path)
+			continue
+		}
+		if err := os.Remove(f.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archived tを含む期間のアーカイブが存在するか
+func archived(db *DB, t time.Time) (bool, error) {
+	arcs, err := ArcGlob(db)
+	if err != nil {
+		return false, err
+	}
+	for _, f := range arcs {
+		if f.Time.After(t) {
+			continue
+		}
+		a, err := OpenArchive(f.Path)
+		if err != nil {
+			return false, err
+		}
+		a.Close()
+		if t.Before(windowEnd(f.Time, a.Period)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// lastArchivePosition アーカイブの最後の行のPosition
+func lastArchivePosition(path string) (*Position, error) {
+	a, err := OpenArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+	p := *a.Pos
+	err = a.ReadRows(func(row *Row) error {
+		p.Offset = row.Pos.Offset
+		p.HeadHash = row.Pos.HeadHash
+		p.HashLength = row.Pos.HashLength
+		return nil
+	})
+	return &p, err
+}
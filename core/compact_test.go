@@ -0,0 +1,68 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ftailer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r := &DBpool{Path: dir, Name: "name", Period: time.Minute}
+	if _, err := r.Init(); err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2015, 7, 1, 10, 0, 0, 0, time.Local)
+	text := ""
+	for i := 0; i < 5; i++ {
+		s := fmt.Sprintf("line %d\n", i)
+		text += s
+		row := Row{Time: base.Add(time.Duration(i) * time.Minute), Pos: &Position{Name: "test.log", Offset: int64(len(text))}, Text: s}
+		if err := r.Put(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.CloseOldDbs(base.Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	db := &DB{Path: dir, Name: "name"}
+
+	arcs, err := Compact(db, time.Hour, base.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(arcs) != 1 {
+		t.Fatalf("Compact => %v", arcs)
+	}
+	if fixed, _ := FixGlob(db); len(fixed) != 0 {
+		t.Errorf("FixGlob after Compact => %v", fixed)
+	}
+	a, err := OpenArchive(arcs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := ""
+	if err = a.ReadRows(func(row *Row) error {
+		out += row.Text
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+	if out != text || a.Period != time.Hour || len(a.Inputs()) != 5 {
+		t.Errorf("archive => %q period:%s inputs:%v, want %q", out, a.Period, a.Inputs(), text)
+	}
+	pos, err := (&DBpool{Path: dir, Name: "name"}).searchFixedFile()
+	if err != nil || pos == nil || pos.Offset != int64(len(text)) {
+		t.Errorf("searchFixedFile => %v, %v", pos, err)
+	}
+	if ok, err := archived(db, base.Add(30*time.Minute)); !ok || err != nil {
+		t.Errorf("archived => %v, %v", ok, err)
+	}
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"bytes"
+	"cmp"
 	"compress/zlib"
 	"encoding/binary"
 	"encoding/json"
@@ -9,10 +10,11 @@ import (
 	"hash/fnv"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -29,6 +31,8 @@ type DB struct {
 	Path         string
 	Name         string
 	Time         time.Time
+	Meta         map[string]string // 新規作成時にヘッダに書き込むメタデータ
+	Logger       *slog.Logger      // nilの場合はslog.Default()
 
 	*FtailDB
 	fix bool
@@ -42,6 +46,8 @@ type FtailDB struct {
 	file     *os.File
 	Pos      *Position
 	PosError error
+	Meta     map[string]string // ヘッダに書き込まれたメタデータ
+	version  int               // ヘッダのMetaVersion
 }
 
 type Row struct {
@@ -49,11 +55,57 @@ type Row struct {
 	Pos  *Position `json:"p,omitempty"`
 	Bin  []byte    `json:"b,omitempty"`
 	Text string    `json:"s,omitempty"`
+	Recs []byte    `json:"r,omitempty"` // zlib圧縮したパース結果(1行1JSON)
+	Info *RowInfo  `json:"-"`           // バイナリ形式から読み込んだ場合の情報
+}
+
+// RowInfo バイナリ形式の行のファイル上の情報
+type RowInfo struct {
+	Offset    int64  // ファイル先頭からの行の開始位置
+	Size      int64  // 行のバイト数
+	HeaderSum uint32 // 固定長部分のチェックサム (checksum1)
+	DataSum   uint32 // 行全体のチェックサム (checksum2)
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Records Recsを展開して行毎のパース結果を返す。パースできなかった行はnil
+func (r *Row) Records() ([]map[string]interface{}, error) {
+	if len(r.Recs) == 0 {
+		return nil, nil
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(r.Recs))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	var recs []map[string]interface{}
+	dec := json.NewDecoder(zr)
+	dec.UseNumber()
+	for {
+		var rec map[string]interface{}
+		if err := dec.Decode(&rec); err == io.EOF {
+			return recs, nil
+		} else if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
 }
 
 type FtailDBOptions struct {
 	ReadOnly bool
 	Bin      bool
+	Meta     map[string]string // 新規作成時にヘッダに書き込むメタデータ
 }
 
 func (db *DB) GetPositon() (pos Position, err error) {
@@ -69,7 +121,9 @@ func (db *DB) Create(ext string, pos *Position) error {
 	if err != nil {
 		return err
 	}
-	db.FtailDB, err = FtailDBOpen(db.RealFilePath, 0644, nil, pos)
+	options := *DefaultOptions
+	options.Meta = db.Meta
+	db.FtailDB, err = FtailDBOpen(db.RealFilePath, 0644, &options, pos)
 	if err != nil {
 		db.FtailDB = nil
 		return err
@@ -89,6 +143,7 @@ func (db *DB) Open(ext string, pos *Position) error {
 		db.FtailDB = nil
 		return err
 	}
+	db.Meta = db.FtailDB.Meta
 	//log.Printf("DB was opened.  %s", db.RealFilePath)
 	return err
 }
@@ -100,6 +155,10 @@ func (db *DB) MakeRealFilePath(ext string) string {
 	return strings.TrimSuffix(db.RealFilePath, filepath.Ext(db.RealFilePath)) + ext
 }
 
+func (db *DB) logger() *slog.Logger {
+	return cmp.Or(db.Logger, slog.Default()).With("source", db.Name)
+}
+
 func (db *DB) Close(fix bool) error {
 	if db.FtailDB == nil {
 		return nil
@@ -118,7 +177,7 @@ func (db *DB) Close(fix bool) error {
 		if err := os.Rename(recFilePath, fixFilePath); err != nil {
 			return err
 		}
-		log.Printf("DB was closed.  %s -> %s", recFilePath, fixFilePath)
+		db.logger().Info("DB was closed", "file", fixFilePath)
 		//} else {
 		//	log.Printf("DB was closed.  %s", recFilePath)
 	}
@@ -129,8 +188,8 @@ func (db *DB) Delete(ext string) error {
 		return nil
 	}
 	extFilePath := db.MakeRealFilePath(ext)
-	brokenFilePath := db.MakeRealFilePath("broken")
-	log.Printf("save mv %s -> %s", extFilePath, brokenFilePath)
+	brokenFilePath := db.MakeRealFilePath(BrokenExt)
+	db.logger().Warn("save broken DB", "file", extFilePath, "broken", brokenFilePath)
 	return os.Rename(extFilePath, brokenFilePath)
 }
 
@@ -148,7 +207,7 @@ func FtailDBOpen(path string, mode os.FileMode, options *FtailDBOptions, pos *Po
 	if options == nil {
 		options = DefaultOptions
 	}
-	flag := os.O_RDWR
+	flag := os.O_RDWR | os.O_CREATE
 	if options.ReadOnly {
 		flag = os.O_RDONLY
 		db.readOnly = true
@@ -157,7 +216,7 @@ func FtailDBOpen(path string, mode os.FileMode, options *FtailDBOptions, pos *Po
 		db.bin = true
 	}
 	var err error
-	if db.file, err = os.OpenFile(db.path, flag|os.O_CREATE, mode); err != nil {
+	if db.file, err = os.OpenFile(db.path, flag, mode); err != nil {
 		_ = db.Close()
 		return nil, &InvalidFtailDBError{File: path, S: err.Error()}
 	}
@@ -167,6 +226,7 @@ func FtailDBOpen(path string, mode os.FileMode, options *FtailDBOptions, pos *Po
 		if pos == nil {
 			return nil, &InvalidFtailDBError{File: path, S: "new file pos is nil"}
 		}
+		db.Meta = options.Meta
 		if err := db.writeHeader(pos); err != nil {
 			return nil, &InvalidFtailDBError{File: path, S: err.Error()}
 		}
@@ -184,9 +244,19 @@ func FtailDBOpen(path string, mode os.FileMode, options *FtailDBOptions, pos *Po
 	return db, nil
 }
 
+// writeHeader ヘッダ行を書き込む
+// メタデータはヘッダ行のTextにJSONで保存する
 func (db *FtailDB) writeHeader(pos *Position) error {
 	if db.bin {
-		data, err := encodeRow(Row{Pos: pos})
+		row := Row{Pos: pos}
+		db.Meta = versionMeta(db.Meta)
+		db.version = FormatVersion
+		b, err := json.Marshal(db.Meta)
+		if err != nil {
+			return err
+		}
+		row.Text = string(b)
+		data, err := encodeRow(row)
 		if err != nil {
 			return err
 		}
@@ -204,6 +274,14 @@ func (db *FtailDB) readHeader() (*Position, error) {
 		if err != nil {
 			return nil, err
 		}
+		if row.Text != "" {
+			if err := json.Unmarshal([]byte(row.Text), &db.Meta); err != nil {
+				return nil, fmt.Errorf("invalid header meta: %s", err)
+			}
+		}
+		if db.version, err = formatVersion(db.Meta); err != nil {
+			return nil, err
+		}
 		return row.Pos, nil
 	}
 	dec := json.NewDecoder(db.file)
@@ -263,9 +341,35 @@ func (db *FtailDB) ReadAll(w io.Writer) (int64, *Position, error) {
 	var p = db.Pos
 	line := 0
 	size := int64(0)
+	err := db.ReadRows(func(row *Row) error {
+		line++
+		sz, err := row.WriteTo(w)
+		if err != nil {
+			return &InvalidFtailDBError{Line: line, File: db.path, S: err.Error()}
+		}
+		size += sz
+		p = row.Pos
+		return nil
+	})
+	if err != nil {
+		return size, nil, err
+	}
+	return size, p, nil
+}
+
+// ReadRows 現在の読み込み位置から最後までの行を順番にfnに渡す
+// fnがエラーを返した場合はそのエラーで終了する
+func (db *FtailDB) ReadRows(fn func(row *Row) error) error {
+	line := 0
 	var dec Decoder
+	var offset int64
 	if !db.bin {
 		dec = json.NewDecoder(db.file)
+	} else {
+		var err error
+		if offset, err = db.file.Seek(0, io.SeekCurrent); err != nil {
+			return err
+		}
 	}
 	for {
 		row := &Row{}
@@ -276,8 +380,13 @@ func (db *FtailDB) ReadAll(w io.Writer) (int64, *Position, error) {
 			if err == io.EOF {
 				break
 			} else if err != nil {
-				return size, nil, &InvalidFtailDBError{Line: line, File: db.path, S: err.Error()}
+				return &InvalidFtailDBError{Line: line, File: db.path, S: err.Error()}
+			}
+			if err := checkRecs(db.version, row); err != nil {
+				return &InvalidFtailDBError{Line: line, File: db.path, S: err.Error()}
 			}
+			row.Info.Offset = offset
+			offset += row.Info.Size
 			row.Pos.Name = db.Pos.Name
 			row.Pos.CreateAt = db.Pos.CreateAt
 		} else {
@@ -285,28 +394,56 @@ func (db *FtailDB) ReadAll(w io.Writer) (int64, *Position, error) {
 			if err == io.EOF {
 				break
 			} else if err != nil {
-				return size, nil, &InvalidFtailDBError{Line: line, File: db.path, S: err.Error()}
+				return &InvalidFtailDBError{Line: line, File: db.path, S: err.Error()}
 			}
 		}
-		var sz int64
-		if row.Bin != nil {
-			r, err := zlib.NewReader(bytes.NewReader(row.Bin))
-			if err != nil {
-				return size, nil, &InvalidFtailDBError{Line: line, File: db.path, S: err.Error()}
-			}
-			if sz, err = io.Copy(w, r); err != nil {
-				return size, nil, &InvalidFtailDBError{Line: line, File: db.path, S: err.Error()}
-			}
-		} else {
-			if _, err := io.WriteString(w, row.Text); err != nil {
-				return size, nil, &InvalidFtailDBError{Line: line, File: db.path, S: err.Error()}
-			}
-			sz = int64(len(row.Text))
+		if err := fn(row); err != nil {
+			return err
 		}
-		size += sz
-		p = row.Pos
 	}
-	return size, p, nil
+	return nil
+}
+
+// ReadNextRow 現在の読み込み位置から1行読み込む
+// 最後まで読み込んだ場合、または書き込み途中などで行を読み込めない場合は読み込み位置を戻してエラーを返す
+func (db *FtailDB) ReadNextRow() (*Row, error) {
+	if !db.bin {
+		return nil, &InvalidFtailDBError{File: db.path, S: "ReadNextRow supports only binary format"}
+	}
+	offset, err := db.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	row, err := decodeRow(db.file)
+	if err == nil {
+		err = checkRecs(db.version, row)
+	}
+	if err != nil {
+		if _, serr := db.file.Seek(offset, io.SeekStart); serr != nil {
+			return nil, serr
+		}
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, &InvalidFtailDBError{File: db.path, S: err.Error()}
+	}
+	row.Info.Offset = offset
+	row.Pos.Name = db.Pos.Name
+	row.Pos.CreateAt = db.Pos.CreateAt
+	return row, nil
+}
+
+// WriteTo 行の内容を展開してwに書き込む
+func (r *Row) WriteTo(w io.Writer) (int64, error) {
+	if r.Bin == nil {
+		n, err := io.WriteString(w, r.Text)
+		return int64(n), err
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(r.Bin))
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(w, zr)
 }
 
 type InvalidFtailDBError struct {
@@ -319,6 +456,56 @@ func (e *InvalidFtailDBError) Error() string {
 	return fmt.Sprintf("Invalid FtailDB Error. file:%s count:%d: err:%v", e.File, e.Line, e.S)
 }
 
+// バイナリ形式の1行 (little endian)
+//
+//	Time, CreateAt, Offset (int64), LenBin, LenText (int32), HashLength, LenHeadHash, LenName (int16),
+//	[LenRecs (int32)], checksum1, Bin, Text, HeadHash, Name, [Recs], checksum2
+//
+// LenRecsとRecsはFormatVersion 2で追加し、LenBinにrecsFlagが立っている行にだけある。
+// recsFlagを知らない古いリーダーはLenBinを1GiB以上と解釈して読み込みに失敗するので、
+// Recsを含む可能性があるファイルはヘッダのMetaVersionで判別する
+const recsFlag = int32(1 << 30)
+
+// FormatVersion 書き込むファイルの形式のバージョン。MetaVersionのないファイルは1
+//
+//	1: 最初の形式
+//	2: 行にRecs (recsFlag) を含められる
+const FormatVersion = 2
+
+// formatVersion ヘッダのメタデータのMetaVersion。読み込めない新しい形式の場合はエラー
+func formatVersion(meta map[string]string) (int, error) {
+	s, ok := meta[MetaVersion]
+	if !ok {
+		return 1, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("invalid format version %q", s)
+	}
+	if v > FormatVersion {
+		return 0, fmt.Errorf("unsupported format version %d (supported: %d)", v, FormatVersion)
+	}
+	return v, nil
+}
+
+// checkRecs バージョン1のファイルにrecsFlagのある行は無い
+func checkRecs(version int, row *Row) error {
+	if version < 2 && row.Recs != nil {
+		return fmt.Errorf("row has records in a format version %d file", version)
+	}
+	return nil
+}
+
+// versionMeta MetaVersionを加えたメタデータ
+func versionMeta(meta map[string]string) map[string]string {
+	m := make(map[string]string, len(meta)+1)
+	for k, v := range meta {
+		m[k] = v
+	}
+	m[MetaVersion] = strconv.Itoa(FormatVersion)
+	return m
+}
+
 /*
 type Row struct {
 	Time time.Time `json:"t"`
@@ -336,16 +523,23 @@ type Position struct {
 */
 
 func encodeRow(r Row) ([]byte, error) {
+	lenBin := int32(len(r.Bin))
+	if len(r.Recs) > 0 {
+		lenBin |= recsFlag
+	}
 	var data = []interface{}{
 		r.Time.UnixNano(),
 		r.Pos.CreateAt.UnixNano(),
 		r.Pos.Offset,
-		int32(len(r.Bin)),
+		lenBin,
 		int32(len(r.Text)),
 		int16(r.Pos.HashLength),
 		int16(len(r.Pos.HeadHash)),
 		int16(len(r.Pos.Name)),
 	}
+	if len(r.Recs) > 0 {
+		data = append(data, int32(len(r.Recs)))
+	}
 	buf := &bytes.Buffer{}
 	fnvWriter := fnv.New32a()
 	w := io.MultiWriter(buf, fnvWriter)
@@ -363,6 +557,7 @@ func encodeRow(r Row) ([]byte, error) {
 		[]byte(r.Text),
 		[]byte(r.Pos.HeadHash),
 		[]byte(r.Pos.Name),
+		r.Recs,
 	}
 	for _, v := range dataStream {
 		_, err := w.Write(v)
@@ -376,11 +571,12 @@ func encodeRow(r Row) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func decodeRow(f io.Reader) (*Row, error) {
-	r := Row{Pos: &Position{}}
-	var LenBin, LenText int32
+func decodeRow(rd io.Reader) (*Row, error) {
+	r := Row{Pos: &Position{}, Info: &RowInfo{}}
+	var LenBin, LenText, LenRecs int32
 	var hashLength, LenHeadHash, LenName int16
 	fnvWriter := fnv.New32a()
+	f := &countReader{r: rd}
 	tee := io.TeeReader(f, fnvWriter)
 
 	var times = []*time.Time{
@@ -414,7 +610,15 @@ func decodeRow(f io.Reader) (*Row, error) {
 		if err == io.EOF {
 			return nil, err
 		} else if err != nil {
-			return nil, fmt.Errorf("decodeRow binary.Read failed2: %v", err)
+			return nil, fmt.Errorf("decodeRow binary.Read lengths failed: %v", err)
+		}
+	}
+	if LenBin&recsFlag != 0 {
+		LenBin &^= recsFlag
+		if err := binary.Read(tee, binary.LittleEndian, &LenRecs); err == io.EOF {
+			return nil, err
+		} else if err != nil {
+			return nil, fmt.Errorf("decodeRow binary.Read LenRecs failed: %v", err)
 		}
 	}
 	sum := fnvWriter.Sum32()
@@ -428,14 +632,16 @@ func decodeRow(f io.Reader) (*Row, error) {
 	if checkSum != sum {
 		return nil, fmt.Errorf("decodeRow checksum1 does not match. f:%x sum:%x", checkSum, sum)
 	}
+	r.Info.HeaderSum = checkSum
 	r.Pos.HashLength = int64(hashLength)
 	r.Bin = make([]byte, LenBin)
 	Text := make([]byte, LenText)
 	HeadHash := make([]byte, LenHeadHash)
 	Name := make([]byte, LenName)
-	var dataStream = [][]byte{r.Bin, Text, HeadHash, Name}
+	r.Recs = make([]byte, LenRecs)
+	var dataStream = [][]byte{r.Bin, Text, HeadHash, Name, r.Recs}
 	for _, v := range dataStream {
-		if _, terr := tee.Read(v); terr == io.EOF {
+		if _, terr := io.ReadFull(tee, v); terr == io.EOF {
 			return nil, terr
 		} else if terr != nil {
 			return nil, fmt.Errorf("decodeRow tee.Read failed: %v", terr)
@@ -451,11 +657,16 @@ func decodeRow(f io.Reader) (*Row, error) {
 	if checkSum != sum {
 		return nil, fmt.Errorf("decodeRow checksum2 does not match. f:%x sum:%x", checkSum, sum)
 	}
+	r.Info.DataSum = checkSum
+	r.Info.Size = f.n
 	r.Text = string(Text)
 	r.Pos.HeadHash = string(HeadHash)
 	r.Pos.Name = string(Name)
 	if LenBin == 0 {
 		r.Bin = nil
 	}
+	if LenRecs == 0 {
+		r.Recs = nil
+	}
 	return &r, nil
 }
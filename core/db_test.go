@@ -2,6 +2,11 @@ package core
 
 import (
 	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -57,3 +62,103 @@ func TestEncodeRowDecodeRow(t *testing.T) {
 		}
 	}
 }
+
+func TestEncodeRowDecodeRowRecs(t *testing.T) {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	if _, err := w.Write([]byte("{\"a\":\"b\"}\nnull\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testData := Row{Text: "a\nb\n", Pos: &Position{Offset: 4}, Recs: b.Bytes()}
+	data, err := encodeRow(testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, err := decodeRow(bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.Text != testData.Text || !bytes.Equal(row.Recs, testData.Recs) {
+		t.Errorf("row:(%#v) != testData:(%#v)", row, testData)
+	}
+	recs, err := row.Records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 || recs[0]["a"] != "b" || recs[1] != nil {
+		t.Errorf("row.Records() => %#v", recs)
+	}
+}
+
+func TestDBpoolHeaderMeta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ftailer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r := &DBpool{Path: dir, Name: "name", Period: time.Minute, Meta: map[string]string{"env": "test"}}
+	if _, err := r.Init(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Truncate(time.Minute)
+	fi, _ := os.Stat(dir)
+	db, err := r.CreateDB(now, &Position{Name: dir, Info: fi})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := db.RealFilePath
+	r.AllClose()
+	fdb, err := FtailDBOpen(path, 0644, &FtailDBOptions{ReadOnly: true, Bin: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fdb.Close()
+	if fdb.Meta["env"] != "test" || fdb.Meta[MetaSource] != "name" || fdb.Meta[MetaPath] != dir {
+		t.Errorf("fdb.Meta => %v", fdb.Meta)
+	}
+	if ino, ok := fileInode(fi); ok && fdb.Meta[MetaInode] != strconv.FormatUint(ino, 10) {
+		t.Errorf("fdb.Meta[%s] => %q, want %d", MetaInode, fdb.Meta[MetaInode], ino)
+	}
+}
+
+func TestFormatVersion(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, meta map[string]string, rows ...Row) string {
+		path := dir + "/" + name
+		b, _ := json.Marshal(meta)
+		var buf bytes.Buffer
+		for _, r := range append([]Row{{Pos: &Position{Name: "a"}, Text: string(b)}}, rows...) {
+			data, err := encodeRow(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf.Write(data)
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	row := Row{Time: time.Now(), Pos: &Position{Offset: 1}, Text: "a\n", Recs: []byte("r")}
+	for _, e := range []struct {
+		meta map[string]string
+		ok   bool
+	}{
+		{versionMeta(nil), true},
+		{map[string]string{}, false},                 // バージョン1にRecsのある行
+		{map[string]string{MetaVersion: "3"}, false}, // 未知の新しい形式
+	} {
+		path := write("v"+e.meta[MetaVersion], e.meta, row)
+		fdb, err := FtailDBOpen(path, 0644, &FtailDBOptions{ReadOnly: true, Bin: true}, nil)
+		if err == nil {
+			err = fdb.ReadRows(func(*Row) error { return nil })
+			fdb.Close()
+		}
+		if (err == nil) != e.ok {
+			t.Errorf("meta %v: err %v", e.meta, err)
+		}
+	}
+}
@@ -23,6 +23,10 @@ func FixGlob(db *DB) ([]DBFiles, error) {
 	return dbGlob(db, FixExt)
 }
 
+func BrokenGlob(db *DB) ([]DBFiles, error) {
+	return dbGlob(db, BrokenExt)
+}
+
 /*
 func dbGlob(db *DB, ext string) ([]DBFiles, error) {
 	var lenExt = len(ext)
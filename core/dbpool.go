@@ -1,8 +1,12 @@
 package core
 
 import (
+	"cmp"
 	"errors"
-	"log"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
 	"time"
 )
 
@@ -11,10 +15,16 @@ type DBpool struct {
 	Name   string
 	inTime time.Time
 	//outTime time.Time
-	Period time.Duration // time.Minute
+	Period time.Duration     // time.Minute
+	Meta   map[string]string // 新規DBのヘッダに書き込むタグ
+	Logger *slog.Logger      // nilの場合はslog.Default()
 	dbs    map[time.Time]*DB
 }
 
+func (r *DBpool) logger() *slog.Logger {
+	return cmp.Or(r.Logger, slog.Default()).With("source", r.Name)
+}
+
 var (
 	ErrTimePast = errors.New("Time is past.")
 	ErrNotFound = errors.New("Key does not exist.")
@@ -32,12 +42,13 @@ func (r *DBpool) openPool(t time.Time) (*DB, Position, error) {
 		}
 		return db, p, nil
 	}
-	db = &DB{Name: r.Name, Path: r.Path, Time: t}
+	db = &DB{Name: r.Name, Path: r.Path, Time: t, Logger: r.Logger}
 	if err = db.Open(recExt, nil); err != nil {
 		if serr, ok := err.(*InvalidFtailDBError); ok {
 			cerr := db.Close(false)
 			derr := db.Delete(recExt)
-			log.Fatalf("Recovered in DBpool.open : %s, db.Close ret:%v, db.Delete ret:%v", serr, cerr, derr)
+			r.logger().Error("recovered in DBpool.open", "err", serr, "close_err", cerr, "delete_err", derr)
+			os.Exit(1)
 		} else {
 			return nil, p, err
 		}
@@ -54,7 +65,7 @@ func (r *DBpool) openPool(t time.Time) (*DB, Position, error) {
 	if p, err = db.GetPositon(); err != nil {
 		return nil, p, err
 	}
-	//log.Printf("opened DB.: %s:%v", r.Name, t) //TODO: test
+	r.logger().Debug("opened DB", "period", t)
 	r.dbs[t] = db
 	return db, p, nil
 }
@@ -62,26 +73,42 @@ func (r *DBpool) openPool(t time.Time) (*DB, Position, error) {
 // CreateDB
 func (r *DBpool) CreateDB(t time.Time, pos *Position) (*DB, error) {
 	if r.inTime.Sub(t) > 0 {
-		//log.Printf("%s. 'inTime:%s > baseTime:%s'", ErrTimePast, r.inTime, t)
 		return nil, ErrTimePast
 	}
 	db, ok := r.dbs[t]
 	if ok { //  存在している
 		return db, nil
 	}
-	db = &DB{Name: r.Name, Path: r.Path, Time: t}
+	db = &DB{Name: r.Name, Path: r.Path, Time: t, Meta: r.headerMeta(pos), Logger: r.Logger}
 	if err := db.Create(recExt, pos); err != nil {
 		return nil, err
 	}
 	if err := db.Put(Row{Time: t, Pos: pos}); err != nil {
 		return nil, err
 	}
-	//log.Printf("DB was created.: %s:%v", r.Name, t) // TODO: test
+	r.logger().Debug("DB was created", "period", t)
 	r.dbs[t] = db
 	r.inTime = t
 	return db, nil
 }
 
+// headerMeta 静的なタグに読み込み中のファイルの情報を加える
+func (r *DBpool) headerMeta(pos *Position) map[string]string {
+	meta := make(map[string]string, len(r.Meta)+3)
+	for k, v := range r.Meta {
+		meta[k] = v
+	}
+	meta[MetaSource] = r.Name
+	if pos == nil || pos.Name == "" {
+		return meta
+	}
+	meta[MetaPath] = pos.Name
+	if ino, ok := fileInode(pos.Info); ok {
+		meta[MetaInode] = strconv.FormatUint(ino, 10)
+	}
+	return meta
+}
+
 func (r *DBpool) isOpen(t time.Time) *DB {
 	db, ok := r.dbs[t]
 	if !ok {
@@ -90,16 +117,33 @@ func (r *DBpool) isOpen(t time.Time) *DB {
 	return db
 }
 
+// Len 開いているDBの数
+func (r *DBpool) Len() int { return len(r.dbs) }
+
+// Files 開いているDBのファイルのパス
+func (r *DBpool) Files() []string {
+	res := make([]string, 0, len(r.dbs))
+	for _, db := range r.dbs {
+		res = append(res, db.RealFilePath)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// InTime 最後に作成したDBの時刻
+func (r *DBpool) InTime() time.Time { return r.inTime }
+
 // Put
 func (r *DBpool) Put(row Row) error {
-	baseTime := row.Time.Truncate(r.Period)
-	//log.Printf("inTime:%s baseTime:%s", r.inTime, baseTime) //TODO: test
+	return r.PutIn(row.Time.Truncate(r.Period), row)
+}
+
+// PutIn row.Timeに関わらずbaseTimeのDBに書き込む
+func (r *DBpool) PutIn(baseTime time.Time, row Row) error {
 	if r.inTime.Sub(baseTime) > 0 {
-		//log.Printf("%s. 'inTime:%s > baseTime:%s'", ErrTimePast, r.inTime, baseTime)
 		return ErrTimePast
 	}
 	if r.inTime.Sub(baseTime) < 0 {
-		//log.Printf("r.Close(%s)", r.inTime) //TODO: test
 		if err := r.Close(r.inTime, true); err != nil {
 			return err
 		}
@@ -108,7 +152,7 @@ func (r *DBpool) Put(row Row) error {
 	db := r.isOpen(baseTime)
 	if db == nil {
 		if db, err = r.CreateDB(baseTime, row.Pos); err != nil {
-			log.Printf("r.CreateDB(%v) err:%v", baseTime, err)
+			r.logger().Error("CreateDB failed", "period", baseTime, "err", err)
 			return err
 		}
 	}
@@ -118,6 +162,32 @@ func (r *DBpool) Put(row Row) error {
 	return nil
 }
 
+// PutPast inTimeより過去のrowを元の時刻のDBに書き込む
+// そのDBが既に閉じられて.fixedになっている場合はErrTimePastを返す
+func (r *DBpool) PutPast(row Row) error {
+	baseTime := row.Time.Truncate(r.Period)
+	if r.inTime.Sub(baseTime) <= 0 {
+		return r.PutIn(baseTime, row)
+	}
+	db := r.isOpen(baseTime)
+	if db == nil {
+		db = &DB{Name: r.Name, Path: r.Path, Time: baseTime, Meta: r.headerMeta(row.Pos), Logger: r.Logger}
+		if _, err := os.Stat(db.MakeFilefullPath(FixExt)); err == nil {
+			return ErrTimePast
+		}
+		if ok, err := archived(db, baseTime); err != nil {
+			return err
+		} else if ok {
+			return ErrTimePast
+		}
+		if err := db.Create(recExt, row.Pos); err != nil {
+			return err
+		}
+		r.dbs[baseTime] = db
+	}
+	return db.Put(row)
+}
+
 // Close
 func (r *DBpool) Close(t time.Time, fix bool) error {
 	db, ok := r.dbs[t]
@@ -125,10 +195,10 @@ func (r *DBpool) Close(t time.Time, fix bool) error {
 		return nil
 	}
 	if err := db.Close(fix); err != nil {
-		log.Printf("Close err :%s , %s db.DB:%# v", err, t, db)
+		r.logger().Error("close failed", "period", t, "file", db.RealFilePath, "err", err)
 		return err
 	}
-	//log.Printf("DBpool: DB was closed. %s:%s", r.Name, t)  //TODO: test
+	r.logger().Debug("DB was closed", "period", t)
 	delete(r.dbs, t)
 	return nil
 }
@@ -137,20 +207,32 @@ func (r *DBpool) Close(t time.Time, fix bool) error {
 func (r *DBpool) AllClose() {
 	for k, db := range r.dbs {
 		if err := db.Close(false); err != nil {
-			log.Printf("db.Close err:%s", err)
+			r.logger().Error("close failed", "file", db.RealFilePath, "err", err)
 		}
 		delete(r.dbs, k)
 	}
 	r.dbs = nil
 }
 
+// AllFix 全てのDBを閉じて.fixedにする
+func (r *DBpool) AllFix() error {
+	for k, db := range r.dbs {
+		if err := db.Close(true); err != nil {
+			r.logger().Error("close failed", "file", db.RealFilePath, "err", err)
+			return err
+		}
+		delete(r.dbs, k)
+	}
+	return nil
+}
+
 func (r *DBpool) CloseOldDbs(t time.Time) (int, error) {
 	for k, db := range r.dbs {
 		elapsed := db.Time.Add(r.Period + delay).Sub(t)
 		if elapsed <= 0 {
-			//log.Printf("Closed the DB files of old time. wait:%v t:%s", elapsed, db.Time)
+			r.logger().Debug("close the DB of old time", "period", db.Time, "elapsed", elapsed)
 			if err := db.Close(true); err != nil {
-				log.Printf("db.Close err:%s", err)
+				r.logger().Error("close failed", "file", db.RealFilePath, "err", err)
 				return len(r.dbs), err
 			}
 			delete(r.dbs, k)
@@ -172,27 +254,44 @@ func (r *DBpool) Init() (pos *Position, err error) {
 	}
 
 	// fixed fileを検索
-	return searchFixedFile(r.Path, r.Name)
+	return r.searchFixedFile()
 }
 
-func searchFixedFile(dbpath, name string) (pos *Position, err error) {
-	db := &DB{Path: dbpath, Name: name}
+func (r *DBpool) searchFixedFile() (pos *Position, err error) {
+	db := &DB{Path: r.Path, Name: r.Name, Logger: r.Logger}
 	dbfiles, err := FixGlob(db)
-	if err != nil || len(dbfiles) == 0 {
+	if err != nil {
+		return nil, err
+	}
+	arcs, err := ArcGlob(db)
+	if err != nil {
 		return nil, err
 	}
+	// 最後の.fixedより新しいアーカイブがある場合はアーカイブから読み込む
+	if len(arcs) > 0 && (len(dbfiles) == 0 || dbfiles[len(dbfiles)-1].Time.Before(arcs[len(arcs)-1].Time)) {
+		a := arcs[len(arcs)-1]
+		if pos, err = lastArchivePosition(a.Path); err != nil {
+			r.logger().Error("lastArchivePosition failed", "file", a.Path, "err", err)
+			return nil, err
+		}
+		r.logger().Info("load position", "file", a.Path, "pos", pos)
+		return pos, nil
+	}
+	if len(dbfiles) == 0 {
+		return nil, nil
+	}
 	f := dbfiles[len(dbfiles)-1]
 	db.Time = f.Time
 	if err = db.Open(FixExt, nil); err != nil {
-		log.Printf("not found db: %s", f.Path)
+		r.logger().Error("open failed", "file", f.Path, "err", err)
 		return
 	}
 	var p Position
 	if p, err = db.GetPositon(); err != nil {
-		log.Printf("db:%s db.GetPositon err: %s", f.Path, err)
+		r.logger().Error("GetPositon failed", "file", f.Path, "err", err)
 		return nil, err
 	}
-	log.Printf("load positon: %v", p)
+	r.logger().Info("load position", "file", f.Path, "pos", p)
 	return &p, db.Close(false) //確認したfixedファイルは閉じる
 }
 
@@ -209,11 +308,11 @@ func (r *DBpool) recPositon() (*Position, error) {
 	for _, f := range dbfiles {
 
 		if _, p, err = r.openPool(f.Time); err != nil {
-			log.Printf("db openPool(%s) err: %s", f.Path, err)
+			r.logger().Error("openPool failed", "file", f.Path, "err", err)
 			return nil, err
 		}
 
-		log.Printf("load positon: %v", p)
+		r.logger().Info("load position", "file", f.Path, "pos", p)
 	}
 	return &p, err
 }
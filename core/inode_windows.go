@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package core
+
+import "os"
+
+func fileInode(fi os.FileInfo) (uint64, bool) {
+	// No inode on windows
+	return 0, false
+}
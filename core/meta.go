@@ -0,0 +1,14 @@
+package core
+
+import "os"
+
+// ヘッダのメタデータに自動で追加されるキー
+const (
+	MetaSource  = "source"  // DBpool.Name
+	MetaPath    = "path"    // 読み込み中のファイルのパス (Position.Name)
+	MetaInode   = "inode"   // 読み込み中のファイルのinode
+	MetaVersion = "version" // ファイル形式のバージョン (FormatVersion)
+)
+
+// FileInode fiのinode。fiがnilの場合や取得できない環境ではfalse
+func FileInode(fi os.FileInfo) (uint64, bool) { return fileInode(fi) }
@@ -2,15 +2,17 @@ package core
 
 import (
 	"fmt"
+	"os"
 	"time"
 )
 
 type Position struct {
-	Name       string    `json:"n,omitempty"`
-	CreateAt   time.Time `json:"ct,omitempty"`
-	Offset     int64     `json:"o,omitempty"`
-	HeadHash   string    `json:"h,omitempty"`
-	HashLength int64     `json:"hl,omitempty"`
+	Name       string      `json:"n,omitempty"`
+	CreateAt   time.Time   `json:"ct,omitempty"`
+	Offset     int64       `json:"o,omitempty"`
+	HeadHash   string      `json:"h,omitempty"`
+	HashLength int64       `json:"hl,omitempty"`
+	Info       os.FileInfo `json:"-"` // 開いているファイルの情報 (保存しない)
 }
 
 func (p Position) String() string {
@@ -1,6 +1,9 @@
 package core
 
-import "time"
+import (
+	"log/slog"
+	"time"
+)
 
 type Recorder struct {
 	DBpool
@@ -9,13 +12,15 @@ type Recorder struct {
 
 func (r *Recorder) Position() *Position { return r.pos }
 
-func NewRecorder(filePath, name string, period time.Duration) (*Recorder, error) {
+// NewRecorder 最終のDBからPositionを読み込んだRecorder。lがnilの場合はslog.Default()に出力する
+func NewRecorder(filePath, name string, period time.Duration, l *slog.Logger) (*Recorder, error) {
 	var err error
 	r := &Recorder{
 		DBpool: DBpool{
 			Period: period,
 			Path:   filePath,
 			Name:   name,
+			Logger: l,
 		},
 	}
 	r.pos, err = r.Init()
@@ -24,3 +29,17 @@ func NewRecorder(filePath, name string, period time.Duration) (*Recorder, error)
 	}
 	return r, nil
 }
+
+// NewReplayRecorder 既存のDBファイルを読み込まないRecorder
+// 稼働中のRecorderの.recファイルを開かずに過去の期間を書き込む場合に使う
+func NewReplayRecorder(filePath, name string, period time.Duration, l *slog.Logger) *Recorder {
+	return &Recorder{
+		DBpool: DBpool{
+			Period: period,
+			Path:   filePath,
+			Name:   name,
+			Logger: l,
+			dbs:    make(map[time.Time]*DB, 0),
+		},
+	}
+}
@@ -0,0 +1,234 @@
+package core
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// BrokenExt DB.Deleteで退避したファイルの拡張子
+const BrokenExt = "broken"
+
+// VerifyResult Verifyの結果
+type VerifyResult struct {
+	Path      string
+	Header    *Position
+	Meta      map[string]string
+	FileSize  int64
+	GoodSize  int64 // 先頭から連続して正常な行の終わりの位置
+	Rows      int   // 正常な行数 (ヘッダを除く)
+	Salvaged  int   // 壊れた部分より後ろで見つかった正常な行数
+	FirstTime time.Time
+	LastTime  time.Time
+	First     *Position // 最初の行の読み込み開始位置 (Offset - 行の長さ)
+	Last      *Position // 最後の行のPosition
+	Errors    []string
+	Warnings  []string
+}
+
+// OK エラーがない
+func (v *VerifyResult) OK() bool { return len(v.Errors) == 0 }
+
+func (v *VerifyResult) errorf(format string, a ...interface{}) {
+	v.Errors = append(v.Errors, fmt.Sprintf(format, a...))
+}
+
+func (v *VerifyResult) warnf(format string, a ...interface{}) {
+	v.Warnings = append(v.Warnings, fmt.Sprintf(format, a...))
+}
+
+// verifiedRow 検証済みの行とファイル上の位置
+type verifiedRow struct {
+	*Row
+	offset int64
+	size   int64 // 展開後のペイロードの長さ
+}
+
+// Verify バイナリ形式のFtailDBファイルのヘッダ, 両方のチェックサム, zlibペイロード,
+// Position.Offsetの連続性を検証する
+func Verify(path string) (*VerifyResult, error) {
+	res, _, err := verify(path)
+	return res, err
+}
+
+func verify(path string) (*VerifyResult, []verifiedRow, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	res := &VerifyResult{Path: path, FileSize: int64(len(data))}
+	r := bytes.NewReader(data)
+	header, err := decodeRow(r)
+	if err != nil {
+		res.errorf("header: %s", err)
+		return res, nil, nil
+	}
+	res.Header = header.Pos
+	res.GoodSize = header.Info.Size
+	if header.Text != "" {
+		if err := json.Unmarshal([]byte(header.Text), &res.Meta); err != nil {
+			res.errorf("header meta: %s", err)
+		}
+	}
+	version, err := formatVersion(res.Meta)
+	if err != nil {
+		res.errorf("header: %s", err)
+		return res, nil, nil
+	}
+	var rows []verifiedRow
+	prev := header.Pos
+	broken := false
+	for offset := res.GoodSize; offset < res.FileSize; {
+		row, err := decodeRow(bytes.NewReader(data[offset:]))
+		if err == nil {
+			err = checkRecs(version, row)
+		}
+		if err != nil {
+			if !broken {
+				res.errorf("offset %d: %s", offset, err)
+				broken = true
+			}
+			offset++ // 次の正常な行を探す
+			continue
+		}
+		vr := verifiedRow{Row: row, offset: offset}
+		if vr.size, err = payloadSize(row); err != nil {
+			res.errorf("offset %d: %s", offset, err)
+			broken = true
+			offset += row.Info.Size
+			continue
+		}
+		if broken {
+			res.Salvaged++
+		} else {
+			res.Rows++
+			res.GoodSize = offset + row.Info.Size
+		}
+		res.checkOffset(offset, prev, vr)
+		if res.First == nil && vr.size > 0 {
+			first := *row.Pos
+			first.Offset -= vr.size
+			res.First = &first
+		}
+		if res.FirstTime.IsZero() {
+			res.FirstTime = row.Time
+		}
+		res.LastTime = row.Time
+		res.Last = row.Pos
+		prev = row.Pos
+		rows = append(rows, vr)
+		offset += row.Info.Size
+	}
+	return res, rows, nil
+}
+
+// payloadSize zlibペイロードとRecsを展開して長さを返す
+func payloadSize(row *Row) (int64, error) {
+	n, err := row.WriteTo(ioutil.Discard)
+	if err != nil {
+		return n, fmt.Errorf("payload: %s", err)
+	}
+	if len(row.Recs) > 0 {
+		zr, err := zlib.NewReader(bytes.NewReader(row.Recs))
+		if err != nil {
+			return n, fmt.Errorf("recs: %s", err)
+		}
+		if _, err = io.Copy(ioutil.Discard, zr); err != nil {
+			return n, fmt.Errorf("recs: %s", err)
+		}
+	}
+	return n, nil
+}
+
+// checkOffset Position.Offsetが減っていないか、ペイロードの長さと一致しているか
+// HeadHashが変わった場合はローテーションとして扱う
+func (v *VerifyResult) checkOffset(offset int64, prev *Position, row verifiedRow) {
+	if prev == nil || prev.HeadHash != row.Pos.HeadHash {
+		return
+	}
+	delta := row.Pos.Offset - prev.Offset
+	switch {
+	case delta == 0 && prev == v.Header:
+		// DBpool.Putで作成したファイルのヘッダは最初の行の後のPosition
+	case delta < 0:
+		v.errorf("offset %d: Position.Offset decreased %d -> %d", offset, prev.Offset, row.Pos.Offset)
+	case delta != row.size:
+		// フィルタや文字コード変換をした場合は一致しない
+		v.warnf("offset %d: Position.Offset advanced %d but payload is %d bytes", offset, delta, row.size)
+	}
+}
+
+// CheckContinuity 同じソースの連続する期間のファイルの間の欠落・重複を確認する
+func CheckContinuity(prev, next *VerifyResult) string {
+	if prev == nil || next == nil || prev.Last == nil || next.First == nil {
+		return ""
+	}
+	if prev.Last.HeadHash != next.First.HeadHash {
+		return "" // ローテーション
+	}
+	switch d := next.First.Offset - prev.Last.Offset; {
+	case d > 0:
+		return fmt.Sprintf("gap %d bytes between %s (offset:%d) and %s (offset:%d)", d, prev.Path, prev.Last.Offset, next.Path, next.First.Offset)
+	case d < 0:
+		return fmt.Sprintf("overlap %d bytes between %s (offset:%d) and %s (offset:%d)", -d, prev.Path, prev.Last.Offset, next.Path, next.First.Offset)
+	}
+	return ""
+}
+
+// RepairTruncate 最後の正常な行の後ろを切り詰める
+func RepairTruncate(res *VerifyResult) error {
+	if res.Header == nil {
+		return fmt.Errorf("%s: header is broken", res.Path)
+	}
+	return os.Truncate(res.Path, res.GoodSize)
+}
+
+// RepairSalvage 正常な行だけを新しいファイルに書き出し、元のファイルと置き換える
+// 元のファイルは拡張子をBrokenExtにして残す。書き出した行数を返す
+func RepairSalvage(path string) (int, error) {
+	res, rows, err := verify(path)
+	if err != nil {
+		return 0, err
+	}
+	if res.Header == nil {
+		return 0, fmt.Errorf("%s: header is broken", path)
+	}
+	// 中断した前回の.salvageが残っていれば作り直す
+	tmp := path + ".salvage"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	done := false
+	defer func() {
+		if !done {
+			os.Remove(tmp)
+		}
+	}()
+	db, err := FtailDBOpen(tmp, 0644, &FtailDBOptions{Bin: true, Meta: res.Meta}, res.Header)
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range rows {
+		if err = db.Put(*r.Row); err != nil {
+			db.Close()
+			return 0, err
+		}
+	}
+	if err = db.Close(); err != nil {
+		return 0, err
+	}
+	d := &DB{RealFilePath: path}
+	if err = os.Rename(path, d.MakeRealFilePath(BrokenExt)); err != nil {
+		return 0, err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return 0, err
+	}
+	done = true
+	return len(rows), nil
+}
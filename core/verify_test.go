@@ -0,0 +1,87 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestDB(t *testing.T, path string, texts []string) {
+	pos := &Position{Name: "test.log"}
+	db, err := FtailDBOpen(path, 0644, nil, pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range texts {
+		pos.Offset += int64(len(s))
+		if err := db.Put(Row{Time: time.Now(), Pos: pos, Text: s}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyAndRepair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ftailer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "000000"+FixExt)
+	writeTestDB(t, path, []string{"a\n", "bb\n", "ccc\n"})
+
+	res, err := Verify(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.OK() || res.Rows != 3 || res.GoodSize != res.FileSize || len(res.Warnings) != 0 {
+		t.Fatalf("Verify => %+v", res)
+	}
+
+	// 2行目を壊す
+	var offsets []int64
+	fdb, err := FtailDBOpen(path, 0644, &FtailDBOptions{ReadOnly: true, Bin: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fdb.ReadRows(func(r *Row) error {
+		offsets = append(offsets, r.Info.Offset)
+		return nil
+	})
+	fdb.Close()
+	if err != nil || len(offsets) != 3 {
+		t.Fatalf("ReadRows => %v, %v", offsets, err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	data[offsets[1]+10] ^= 0xff
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = Verify(path); err != nil {
+		t.Fatal(err)
+	}
+	if res.OK() || res.Rows != 1 || res.Salvaged != 1 {
+		t.Fatalf("Verify broken => %+v", res)
+	}
+	// 中断した前回の.salvageは使わない
+	if err := ioutil.WriteFile(path+".salvage", data[:offsets[1]], 0644); err != nil {
+		t.Fatal(err)
+	}
+	n, err := RepairSalvage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("RepairSalvage => %d, want 2", n)
+	}
+	if res, err = Verify(path); err != nil || !res.OK() || res.Rows != 2 {
+		t.Errorf("Verify salvaged => %+v, %v", res, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "000000"+BrokenExt)); err != nil {
+		t.Errorf("broken file err:%s", err)
+	}
+}
@@ -0,0 +1,35 @@
+package ftail
+
+import (
+	"context"
+	"time"
+
+	"github.com/masahide/ftailer/core"
+)
+
+// compactInterval CompactPeriod指定時にアーカイブにまとめる期間を確認する間隔
+var compactInterval = 1 * time.Minute
+
+// compactLoop 書き込みが終わった期間の.fixedファイルをCompactPeriod毎のアーカイブにまとめる
+func (f *Ftail) compactLoop(ctx context.Context, workerLimit chan bool) {
+	db := &core.DB{Path: f.BufDir, Name: f.Name, Logger: f.Logger}
+	t := time.NewTicker(compactInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case workerLimit <- true:
+		}
+		// 閉じられていない期間を避けるためPeriod分前までを対象にする
+		if _, err := core.Compact(db, f.CompactPeriod, time.Now().Add(-f.Period)); err != nil {
+			f.logger.Error("compact failed", "err", err)
+		}
+		<-workerLimit
+	}
+}
@@ -0,0 +1,75 @@
+package ftail
+
+import (
+	"time"
+
+	"github.com/masahide/ftailer/core"
+	"github.com/masahide/ftailer/tailex"
+)
+
+// LatePolicy EventTime使用時に、既に閉じた期間の行を書き込む先
+type LatePolicy int
+
+const (
+	// LateCurrent 現在の期間のDBに書き込む (Row.Timeはイベント時刻のまま)
+	LateCurrent LatePolicy = iota
+	// LateOriginal イベント時刻の期間のDBに書き込む。既に.fixedになっている場合は現在の期間に書き込む
+	LateOriginal
+	// LateFile Name+LateSuffix の別のDBに書き込む
+	LateFile
+)
+
+// LateSuffix LateFileの書き込み先のName
+const LateSuffix = ".late"
+
+// eventTime 行のイベント時刻
+// 取り出せない行(スタックトレースなど複数行のログの続き)は直前の行の時刻、最初の行の場合は読み込んだ時刻
+func (f *Ftail) eventTime(text []byte, readTime time.Time) time.Time {
+	if f.timeEx == nil {
+		return readTime
+	}
+	t, err := f.timeEx.Extract(text)
+	if err != nil {
+		if !f.lastTime.IsZero() {
+			return f.lastTime
+		}
+		return readTime
+	}
+	return t.Local() // DBファイルのパスはtime.Localの時刻で扱う
+}
+
+// put rowをDBに書き込む。EventTime使用時はLatePolicyに従って過去の行を書き込む
+func (f *Ftail) put(row core.Row) error {
+	if f.replay != nil {
+		return f.replay.put(f.rec, row)
+	}
+	err := f.rec.Put(row)
+	if err != core.ErrTimePast || f.timeEx == nil {
+		return err
+	}
+	switch f.LatePolicy {
+	case LateOriginal:
+		if err = f.rec.PutPast(row); err != core.ErrTimePast {
+			return err
+		}
+		f.logger.Warn("period was already fixed. write to current period", "period", row.Time.Truncate(f.Period))
+	case LateFile:
+		if f.late == nil {
+			if f.late, err = core.NewRecorder(f.BufDir, f.Name+LateSuffix, f.Period, f.Logger); err != nil {
+				return err
+			}
+			f.late.Meta = f.rec.Meta
+		}
+		return f.late.PutIn(tailex.Truncate(time.Now(), f.Period), row)
+	}
+	return f.rec.PutIn(f.rec.InTime(), row)
+}
+
+// closeOldLateDbs LateFileの古いDBを閉じる
+func (f *Ftail) closeOldLateDbs(t time.Time) error {
+	if f.late == nil {
+		return nil
+	}
+	_, err := f.late.CloseOldDbs(t)
+	return err
+}
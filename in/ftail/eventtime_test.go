@@ -0,0 +1,78 @@
+package ftail
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/masahide/ftailer/core"
+	"github.com/masahide/ftailer/parser"
+	"github.com/masahide/ftailer/tail"
+)
+
+func TestEventTimeUntimedLines(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{Name: "name", BufDir: dir, Period: time.Minute, MaxBufSize: 1 << 20,
+		EventTime: &parser.TimeExtractor{Layout: time.RFC3339}}
+	f, err := newFtail(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.rec, err = core.NewRecorder(dir, c.Name, c.Period, nil); err != nil {
+		t.Fatal(err)
+	}
+	f.Pos = &core.Position{Name: "a.log"}
+	f.Writer = NopCloser(&f.buf)
+	workerLimit := make(chan bool, 1)
+	now := time.Now()
+	lines := []*tail.Line{
+		{Text: []byte("2015-07-01T10:00:10Z a\n")},
+		{Text: []byte("\tat stack1\n")}, // 直前の行の期間
+		{NotifyType: tail.TickerNotify},
+		{Text: []byte("\tat stack2\n")},
+		{Text: []byte("2015-07-01T10:01:10Z b\n")},
+		{NotifyType: tail.TickerNotify},
+		{Text: []byte("continued\n")},
+	}
+	for i, l := range lines {
+		l.Time, l.Filename, l.Offset = now, "a.log", int64(i+1)
+		if err := f.lineNotifyAction(context.Background(), l, workerLimit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.rec.AllFix(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[time.Time]string{
+		time.Date(2015, 7, 1, 10, 0, 0, 0, time.UTC): "2015-07-01T10:00:10Z a\n\tat stack1\n\tat stack2\n",
+		time.Date(2015, 7, 1, 10, 1, 0, 0, time.UTC): "2015-07-01T10:01:10Z b\ncontinued\n",
+	}
+	got := map[time.Time]string{}
+	files, err := core.FixGlob(&core.DB{Path: dir, Name: "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fx := range files {
+		db, err := core.FtailDBOpen(fx.Path, 0644, &core.FtailDBOptions{ReadOnly: true, Bin: true}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.ReadRows(func(row *core.Row) error {
+			got[row.Time.Truncate(time.Minute).UTC()] += row.Text
+			return nil
+		})
+		db.Close()
+	}
+	for period, text := range want {
+		if got[period.UTC()] != text {
+			t.Errorf("period %s: got %q, want %q", period, got[period.UTC()], text)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("periods => %v", got)
+	}
+}
@@ -0,0 +1,151 @@
+package ftail
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sync/atomic"
+)
+
+// FilterAction Filterの処理の種類
+type FilterAction int
+
+const (
+	// Include Patternに一致しない行を捨てる
+	Include FilterAction = iota
+	// Exclude Patternに一致する行を捨てる
+	Exclude
+	// Redact 一致した部分(サブマッチがある場合はサブマッチ部分)をReplaceで置き換える
+	Redact
+	// Rewrite 一致した部分をReplaceで書き換える ($1 などで参照可能)
+	Rewrite
+)
+
+const defaultRedactText = "[REDACTED]"
+
+func (a FilterAction) String() string {
+	switch a {
+	case Include:
+		return "include"
+	case Exclude:
+		return "exclude"
+	case Redact:
+		return "redact"
+	case Rewrite:
+		return "rewrite"
+	}
+	return fmt.Sprintf("FilterAction(%d)", int(a))
+}
+
+// Filter 行の絞り込み・加工ルール。Config.Filtersの順番に適用される
+type Filter struct {
+	Name    string // カウンタの名前 (空の場合は "action:pattern")
+	Action  FilterAction
+	Pattern string
+	Replace string
+}
+
+// FilterCount ルール毎の適用行数
+type FilterCount struct {
+	Name    string
+	Matched uint64 // Patternに一致した行数
+	Dropped uint64 // 捨てた行数
+}
+
+type filter struct {
+	Filter
+	re      *regexp.Regexp
+	matched uint64
+	dropped uint64
+}
+
+type filters []*filter
+
+func newFilters(fs []Filter) (filters, error) {
+	res := make(filters, 0, len(fs))
+	for _, f := range fs {
+		re, err := regexp.Compile(f.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("filter %s: %s", f.Pattern, err)
+		}
+		if f.Name == "" {
+			f.Name = f.Action.String() + ":" + f.Pattern
+		}
+		if f.Action == Redact && f.Replace == "" {
+			f.Replace = defaultRedactText
+		}
+		res = append(res, &filter{Filter: f, re: re})
+	}
+	return res, nil
+}
+
+// apply 行にルールを順番に適用する。行を捨てる場合はfalseを返す
+func (fs filters) apply(text []byte) ([]byte, bool) {
+	if len(fs) == 0 {
+		return text, true
+	}
+	// 改行を除いて処理する
+	body := bytes.TrimRight(text, "\r\n")
+	eol := text[len(body):]
+	for _, f := range fs {
+		match := f.re.Match(body)
+		if match {
+			atomic.AddUint64(&f.matched, 1)
+		}
+		switch f.Action {
+		case Include:
+			if !match {
+				atomic.AddUint64(&f.dropped, 1)
+				return nil, false
+			}
+		case Exclude:
+			if match {
+				atomic.AddUint64(&f.dropped, 1)
+				return nil, false
+			}
+		case Redact:
+			if match {
+				body = f.redact(body)
+			}
+		case Rewrite:
+			if match {
+				body = f.re.ReplaceAll(body, []byte(f.Replace))
+			}
+		}
+	}
+	res := make([]byte, 0, len(body)+len(eol))
+	res = append(res, body...)
+	return append(res, eol...), true
+}
+
+func (f *filter) redact(body []byte) []byte {
+	if f.re.NumSubexp() == 0 {
+		return f.re.ReplaceAllLiteral(body, []byte(f.Replace))
+	}
+	var res []byte
+	last := 0
+	for _, m := range f.re.FindAllSubmatchIndex(body, -1) {
+		for i := 2; i < len(m); i += 2 {
+			if m[i] < last { // 未マッチ(-1)または重複したサブマッチ
+				continue
+			}
+			res = append(res, body[last:m[i]]...)
+			res = append(res, f.Replace...)
+			last = m[i+1]
+		}
+	}
+	return append(res, body[last:]...)
+}
+
+// counts ルール毎の適用行数を返す
+func (fs filters) counts() []FilterCount {
+	res := make([]FilterCount, len(fs))
+	for i, f := range fs {
+		res[i] = FilterCount{
+			Name:    f.Name,
+			Matched: atomic.LoadUint64(&f.matched),
+			Dropped: atomic.LoadUint64(&f.dropped),
+		}
+	}
+	return res
+}
@@ -0,0 +1,38 @@
+package ftail
+
+import "testing"
+
+func TestFiltersApply(t *testing.T) {
+	fs, err := newFilters([]Filter{
+		{Action: Exclude, Pattern: `GET /health`},
+		{Action: Redact, Pattern: `\b\d{4}-\d{4}-\d{4}-\d{4}\b`},
+		{Action: Redact, Pattern: `token=(\w+)`, Replace: "xxx"},
+		{Action: Rewrite, Pattern: `^(\S+) (\S+)`, Replace: "$2 $1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var applyTest = []struct {
+		input  string
+		output string
+		ok     bool
+	}{
+		{"a GET /health\n", "", false},
+		{"a b card=1234-5678-9012-3456\n", "b a card=[REDACTED]\n", true},
+		{"a b token=abc&token=def\r\n", "b a token=xxx&token=xxx\r\n", true},
+		{"a b", "b a", true},
+	}
+	for _, e := range applyTest {
+		output, ok := fs.apply([]byte(e.input))
+		if string(output) != e.output || ok != e.ok {
+			t.Errorf("apply(%q) => %q, %v, want %q, %v", e.input, output, ok, e.output, e.ok)
+		}
+	}
+	counts := fs.counts()
+	if counts[0].Matched != 1 || counts[0].Dropped != 1 || counts[2].Matched != 1 {
+		t.Errorf("counts => %+v", counts)
+	}
+	if _, err := newFilters([]Filter{{Pattern: "("}}); err == nil {
+		t.Errorf("newFilters invalid pattern err is nil")
+	}
+}
@@ -4,16 +4,21 @@ import (
 	"bytes"
 	"compress/zlib"
 	"context"
+	"encoding/json"
 	"hash"
 	"hash/fnv"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/masahide/ftailer/core"
+	"github.com/masahide/ftailer/metrics"
+	"github.com/masahide/ftailer/parser"
 	"github.com/masahide/ftailer/tail"
 	"github.com/masahide/ftailer/tailex"
 )
@@ -26,6 +31,17 @@ type Config struct {
 	Period          time.Duration // 分割保存インターバル
 	MaxHeadHashSize int64
 	MaxBufSize      int
+	Filters         []Filter              // 書き込み前に順番に適用する行の絞り込み・加工ルール
+	Parser          parser.Parser         // 指定すると行のパース結果を元の行と一緒に保存する
+	Tags            map[string]string     // DBのヘッダに書き込むタグ (hostname, environment, service など)
+	EventTime       *parser.TimeExtractor // 指定すると行の内容の時刻でDBを期間分割する
+	LatePolicy      LatePolicy            // EventTime使用時に既に閉じた期間の行の書き込み先
+	CompactPeriod   time.Duration         // 指定するとこの期間毎に.fixedファイルをアーカイブにまとめる (1時間, 1日など)
+	Start           string                // 保存済みのポジションがない場合の読み込み開始位置 (beginning, end, offset:N, lines:N, time:T)。空の場合はファイルサイズとNoSeekで決める
+	ForceStart      bool                  // 保存済みのポジションがあってもStartから読み直す
+	Input           Input                 // 読み込み元 (file, stdin, fifo, command)。空の場合はファイル
+	Command         []string              // InputCommandで実行するコマンドと引数
+	Listen          string                // InputSyslogUDP, InputSyslogTCP, InputJournalの待ち受けアドレス
 
 	tailex.Config
 }
@@ -41,6 +57,20 @@ type Ftail struct {
 	lastTime time.Time
 	headHash hash.Hash64
 	head     []byte
+	filters  filters
+	pending  bool // 捨てた行によりPositionだけが進んでいる
+	recBuf   bytes.Buffer
+	recEnc   *json.Encoder
+	timeEx   *parser.TimeExtractor
+	start    *StartMode     // Config.Start
+	bufSlice time.Time      // bufに溜まっている行のイベント時刻の期間
+	late     *core.Recorder // LateFile の書き込み先
+	replay   *replayGuard   // Replay時の書き込み先の期間の確認
+	stats    *sourceMetrics
+	status   *sourceStatus
+	logger   *slog.Logger // sourceを付けたLogger
+	opened   bool         // 最初のファイルを開いた
+	reading  bool         // 前回のTickerNotify以降に行を読み込んだ
 }
 
 var tailDefaultConfig = tail.Config{
@@ -51,38 +81,100 @@ var tailDefaultConfig = tail.Config{
 	NotifyInterval: 1 * time.Second,
 }
 
+// TagHostname 指定がない場合はos.Hostname()を使う
+const TagHostname = "hostname"
+
+func tags(t map[string]string) map[string]string {
+	res := make(map[string]string, len(t)+1)
+	for k, v := range t {
+		res[k] = v
+	}
+	if res[TagHostname] == "" {
+		if h, err := os.Hostname(); err == nil {
+			res[TagHostname] = h
+		}
+	}
+	return res
+}
+
+// tailConfig 利用者が指定できる項目以外をデフォルト値にする
+func tailConfig(c tail.Config) tail.Config {
+	tc := tailDefaultConfig
+	tc.Encoding = c.Encoding
+	tc.Poll = c.Poll
+	tc.StatInterval = c.StatInterval
+	tc.PollInterval = c.PollInterval
+	tc.MaxPollInterval = c.MaxPollInterval
+	tc.RemovePolicy = c.RemovePolicy
+	tc.FollowSymlink = c.FollowSymlink
+	return tc
+}
+
+// retargeted FollowSymlinkでリンク先が保存済みのPos.Nameと異なる
+func (f *Ftail) retargeted(c Config) bool {
+	if !c.FollowSymlink || f.Pos.Name == "" || c.PathFmt != "" {
+		return false
+	}
+	link, err := tailex.GlobSearch(c.Path)
+	if err != nil {
+		return false
+	}
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil || target == f.Pos.Name {
+		return false
+	}
+	f.logger.Info("symlink was retargeted while stopped", "old", f.Pos.Name, "new", target)
+	return true
+}
+
 // ポジション情報がない場合に実ファイルから取得
 func (f *Ftail) position(c Config) (pos *core.Position, err error) {
 	var fi os.FileInfo
 	var filePath string
 	if c.PathFmt != "" { // cronolog
-		timeSlice := tailex.Truncate(c.Config.Time, c.RotatePeriod)
+		timeSlice := tailex.Truncate(c.InLocation(c.Config.Time), c.RotatePeriod)
 		searchPath := tailex.Time2Path(c.PathFmt, timeSlice)
 		filePath, err = tailex.GlobSearch(searchPath)
 		if err == tailex.ErrNoSuchFile {
-			log.Printf("ftail position() GlobSearch(%s)  err: %s", searchPath, err)
+			f.logger.Info("position: no such file", "glob", searchPath)
 			return &core.Position{}, nil
 		} else if err != nil {
-			log.Printf("ftail position() GlobSearch(%s)  err: %s", searchPath, err)
+			f.logger.Error("position: GlobSearch failed", "glob", searchPath, "err", err)
 			return nil, err
 		}
 	} else {
 		filePath, err = tailex.GlobSearch(c.Path)
 		if err == tailex.ErrNoSuchFile {
-			log.Printf("ftail position() GlobSearch(%s)  err: %s", c.Path, err)
+			f.logger.Info("position: no such file", "glob", c.Path)
+			return &core.Position{}, nil
+		} else if err != nil {
+			f.logger.Error("position: GlobSearch failed", "glob", c.Path, "err", err)
+			return nil, err
+		}
+	}
+	if c.FollowSymlink { // Pos.Nameはリンク先のパス
+		link := filePath
+		if filePath, err = filepath.EvalSymlinks(link); os.IsNotExist(err) {
+			f.logger.Info("position: no such file", "link", link)
 			return &core.Position{}, nil
 		} else if err != nil {
-			log.Printf("ftail position() GlobSearch(%s)  err: %s", c.Path, err)
+			f.logger.Error("position: EvalSymlinks failed", "file", link, "err", err)
 			return nil, err
 		}
 	}
 	if fi, err = os.Stat(filePath); err != nil {
-		log.Printf("Start os.Stat('%s')  err: %s,  ", filePath, err)
+		f.logger.Error("position: stat failed", "file", filePath, "err", err)
 		return nil, err
 	}
 	offset := int64(0)
-	// 現在のファイルサイズがf.MaxHeadHashSizeより大きいものだけオフセットを現在のサイズにする。
-	if (!c.Config.NoSeek) && (fi.Size() > f.MaxHeadHashSize) {
+	if f.start != nil {
+		if offset, err = startOffset(filePath, *f.start, f.timeEx); err != nil {
+			f.logger.Error("position: start offset failed", "file", filePath, "start", c.Start, "err", err)
+			return nil, err
+		}
+		f.logger.Info("position: start", "file", filePath, "start", c.Start, "offset", offset)
+	} else if (!c.Config.NoSeek) && (fi.Size() > f.MaxHeadHashSize) {
+		// 現在のファイルサイズがf.MaxHeadHashSizeより大きいものだけオフセットを現在のサイズにする。
 		offset = fi.Size()
 	}
 	pos = &core.Position{
@@ -93,64 +185,99 @@ func (f *Ftail) position(c Config) (pos *core.Position, err error) {
 	return
 }
 
-func Start(ctx context.Context, c Config, workerLimit chan bool) error {
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case workerLimit <- true:
+// newFtail Filters, EventTimeを検証してFtailを作成する
+func newFtail(c Config) (*Ftail, error) {
+	fs, err := newFilters(c.Filters)
+	if err != nil {
+		return nil, err
+	}
+	var timeEx *parser.TimeExtractor
+	if c.EventTime != nil {
+		if timeEx, err = parser.NewTimeExtractor(*c.EventTime); err != nil {
+			return nil, err
+		}
 	}
-	f := &Ftail{
+	var start *StartMode
+	if c.Start != "" {
+		m, err := ParseStart(c.Start)
+		if err != nil {
+			return nil, err
+		}
+		if m.Kind == StartTime && timeEx == nil {
+			return nil, errStartTime
+		}
+		start = &m
+	} else if c.ForceStart {
+		return nil, errForceStart
+	}
+	l := c.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+	if c.stream() {
+		if start != nil {
+			return nil, errStreamStart
+		}
+		if _, err := c.opener(l, 0); err != nil {
+			return nil, err
+		}
+	}
+	return &Ftail{
+		logger:   l.With("source", c.Name),
 		Config:   c,
 		headHash: fnv.New64(),
 		head:     []byte{},
+		filters:  fs,
+		timeEx:   timeEx,
+		start:    start,
+		stats:    newSourceMetrics(metrics.Default, c.Name),
+		status:   newSourceStatus(c.Name),
+	}, nil
+}
+
+func Start(ctx context.Context, c Config, workerLimit chan bool) (err error) {
+	f, err := newFtail(c)
+	if err != nil {
+		return err
+	}
+	defer func() { f.status.stopped(err) }()
+	workerLimitMetrics(metrics.Default, workerLimit)
+	if !f.acquire(ctx, workerLimit) {
+		return ctx.Err()
 	}
 	//if f.MaxHeadHashSize == 0 {
 	//	f.MaxHeadHashSize = defaultMaxHeadHashSize
 	//}
-	var err error
-	f.rec, err = core.NewRecorder(c.BufDir, c.Name, c.Period)
+	f.rec, err = core.NewRecorder(c.BufDir, c.Name, c.Period, c.Logger)
 	if err != nil {
-		log.Fatalln("NewRecorder err:", err)
+		f.logger.Error("NewRecorder failed", "err", err)
+		<-workerLimit
+		return err
 	}
 	defer f.rec.AllClose()
+	f.rec.Meta = tags(c.Tags)
 
 	f.Pos = f.rec.Position()
-	if f.Pos == nil {
-		if f.Pos, err = f.position(c); err != nil {
-			log.Fatalln("position err:", err)
-		}
-	}
-	f.Config.Config.Config = tailDefaultConfig
+	f.Config.Config.Config = tailConfig(c.Config.Config)
+	f.Config.Config.Config.Logger = f.logger
 	f.ReOpenDelay = 5 * time.Second
 	if f.Delay != 0 {
 		f.ReOpenDelay = f.Delay
 	}
 	//log.Printf("f.Pos: %s", f.Pos)
 
-	if f.MaxHeadHashSize != 0 && f.Pos.Name != "" {
-		oldhead := f.head
-		hash, length, hherr := f.getHeadHash(f.Pos.Name, f.Pos.HashLength)
-		if hherr != nil {
-			log.Printf("getHeadHash err:%s", hherr)
-		} else {
-			if f.Pos.HeadHash == hash && f.Pos.HashLength == length { // ポジションファイルのハッシュ値と一致した場合はSeekInfoをセット
-				log.Printf("match headHash: %s, head:%s", f.Pos, f.head)
-				f.Location = &tail.SeekInfo{Offset: f.Pos.Offset}
-			} else {
-				log.Printf("not match headHash old: %s, head:%s", f.Pos, oldhead)
-				f.Pos.HeadHash = hash
-				f.Pos.HashLength = length
-				log.Printf("not match headHash new: %s, head:%s", f.Pos, f.head)
-			}
-		}
+	var lines chan *tail.Line
+	if c.stream() {
+		f.MaxHeadHashSize = 0 // 先頭を読み直せないのでハッシュは使わない
+		f.Pos = f.streamPosition(c)
+		lines, err = f.startStream(ctx, c)
 	} else {
-		posTimeSlise := tailex.Truncate(f.Pos.CreateAt, c.RotatePeriod)
-		nowTimeSlise := tailex.Truncate(time.Now(), c.RotatePeriod)
-		if nowTimeSlise.Equal(posTimeSlise) { // 読み込んだポジションのcreateAtが現在のtimesliseと同じ場合
-			f.Location = &tail.SeekInfo{Offset: f.Pos.Offset}
-		}
+		lines, err = f.startFile(ctx, c, workerLimit)
+	}
+	if err != nil {
+		<-workerLimit
+		return err
 	}
-	t := tailex.NewTailEx(ctx, f.Config.Config, workerLimit)
 	//var buf bytes.Buffer
 	f.buf = bytes.Buffer{}
 	/*
@@ -160,10 +287,29 @@ func Start(ctx context.Context, c Config, workerLimit chan bool) error {
 		}
 	*/
 	<-workerLimit
+	if f.CompactPeriod > 0 { // Startの終了時に止める
+		cctx, cancel := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.compactLoop(cctx, workerLimit)
+		}()
+		defer func() {
+			cancel()
+			wg.Wait()
+		}()
+	}
 	f.Writer = NopCloser(&f.buf)
 	defer func() {
 		if err := f.Flush(); err != nil {
-			log.Printf("f.Flush err:%s", err)
+			f.logger.Error("flush failed", "err", err)
+		}
+		for _, fc := range f.filters.counts() {
+			f.logger.Info("filter count", "filter", fc.Name, "matched", fc.Matched, "dropped", fc.Dropped)
+		}
+		if f.late != nil {
+			f.late.AllClose()
 		}
 	}()
 
@@ -171,7 +317,7 @@ func Start(ctx context.Context, c Config, workerLimit chan bool) error {
 		select {
 		case <-ctx.Done(): // キャンセル処理
 			return ctx.Err()
-		case line, ok := <-t.Lines: // 新しい入力行の取得
+		case line, ok := <-lines: // 新しい入力行の取得
 			if !ok {
 				return err
 			}
@@ -185,28 +331,88 @@ func Start(ctx context.Context, c Config, workerLimit chan bool) error {
 
 }
 
+// startFile 保存済みのポジションかStartの位置からファイルを読み込むTailExを開始する
+func (f *Ftail) startFile(ctx context.Context, c Config, workerLimit chan bool) (chan *tail.Line, error) {
+	var err error
+	if f.Pos != nil && c.ForceStart {
+		f.logger.Info("ignore the stored position", "pos", f.Pos, "start", c.Start)
+		f.Pos = nil
+	}
+	started := false       // Startの位置から読み込む
+	stored := f.Pos != nil // Recorderから読み込んだポジション
+	if f.Pos == nil {
+		if f.Pos, err = f.position(c); err != nil {
+			return nil, err
+		}
+		started = f.start != nil && f.Pos.Name != ""
+	}
+	if started {
+		f.Location = &tail.SeekInfo{Offset: f.Pos.Offset}
+	} else if f.MaxHeadHashSize != 0 && f.Pos.Name != "" {
+		oldhead := f.head
+		hash, length, hherr := f.getHeadHash(f.Pos.Name, f.Pos.HashLength)
+		if hherr != nil {
+			f.logger.Warn("getHeadHash failed", "file", f.Pos.Name, "err", hherr)
+		} else {
+			if f.Pos.HeadHash == hash && f.Pos.HashLength == length { // ポジションファイルのハッシュ値と一致した場合はSeekInfoをセット
+				f.logger.Info("match headHash", "pos", f.Pos)
+				f.logger.Debug("head", "head", string(f.head))
+				f.Location = &tail.SeekInfo{Offset: f.Pos.Offset}
+			} else {
+				f.logger.Info("not match headHash", "old", f.Pos, "hash", hash, "hash_length", length)
+				f.logger.Debug("head", "old", string(oldhead), "new", string(f.head))
+				f.Pos.HeadHash = hash
+				f.Pos.HashLength = length
+			}
+		}
+	} else {
+		posTime := f.Pos.CreateAt
+		if c.PathFmt != "" { // ファイル名の日付が分かればそれを使う
+			if t, perr := tailex.ParsePath(c.PathFmt, f.Pos.Name, c.PathLocation); perr == nil {
+				posTime = t
+			}
+		}
+		posTimeSlise := tailex.Truncate(c.InLocation(posTime), c.RotatePeriod)
+		nowTimeSlise := tailex.Truncate(c.InLocation(time.Now()), c.RotatePeriod)
+		if nowTimeSlise.Equal(posTimeSlise) { // 読み込んだポジションのcreateAtが現在のtimesliseと同じ場合
+			f.Location = &tail.SeekInfo{Offset: f.Pos.Offset}
+		}
+	}
+	if stored && f.Location != nil && f.retargeted(c) { // 停止中にリンクが付け替えられた場合は新しいリンク先を先頭から読む
+		f.Location = nil
+	}
+	return tailex.NewTailEx(ctx, f.Config.Config, workerLimit).Lines, nil
+}
+
 // lineのNotifyType別に処理を分岐
 func (f *Ftail) lineNotifyAction(ctx context.Context, line *tail.Line, workerLimit chan bool) error {
 	var err error
+	defer f.status.notify(line, f.Pos)
 
 	if line.NotifyType == tail.NewLineNotify { // 新しいライン
+		if line.Err != nil {
+			f.logger.Warn("read line", "file", line.Filename, "offset", line.Offset, "err", line.Err)
+			f.error(errRead, line.Err)
+		}
+		f.stats.lines.Inc()
+		if line.Raw != nil {
+			f.stats.bytesRead.Add(uint64(len(line.Raw)))
+		} else {
+			f.stats.bytesRead.Add(uint64(len(line.Text)))
+		}
 		if err = f.Write(line); err != nil {
 			return err
 		}
 		if f.buf.Len() < f.MaxBufSize {
 			return err
 		}
-		select {
-		case workerLimit <- true:
-			defer func() { <-workerLimit }()
-		case <-ctx.Done():
+		if !f.acquire(ctx, workerLimit) {
 			return ctx.Err()
 		}
+		defer func() { <-workerLimit }()
 		return f.Flush()
 	}
-	select {
-	case <-ctx.Done():
-	case workerLimit <- true:
+	if f.acquire(ctx, workerLimit) {
 		defer func() { <-workerLimit }()
 	}
 	switch line.NotifyType {
@@ -215,23 +421,51 @@ func (f *Ftail) lineNotifyAction(ctx context.Context, line *tail.Line, workerLim
 			return err
 		}
 		timeSlice := tailex.Truncate(line.Time, f.Period)
-		if f.lastSlice.Sub(timeSlice) < 0 {
+		// EventTime使用時は行の期間のDBをputで開く。現在の期間を開くとバックログの行が全て過去になる
+		if f.timeEx == nil && f.lastSlice.Sub(timeSlice) < 0 {
 			// 新しいDBを開く
 			if _, err = f.rec.CreateDB(timeSlice, f.Pos); err != nil {
-				log.Printf("CreateDB err:%s", err)
+				f.logger.Error("CreateDB failed", "period", timeSlice, "err", err)
+				f.error(errCreateDB, err)
 				return err
 			}
 			f.lastSlice = timeSlice
 		}
 		// 古いDBを閉じる
-		if _, cerr := f.rec.CloseOldDbs(line.Time); cerr != nil {
-			log.Printf("CloseOldDbs err:%s", cerr)
+		// EventTime使用時は読み込み中(前回の通知以降に行があった)ならイベント時刻、止まっていれば現在時刻で判定する
+		closeTime := line.Time
+		if f.timeEx != nil && f.reading && !f.lastTime.IsZero() {
+			closeTime = f.lastTime
+		}
+		f.reading = false
+		if _, cerr := f.rec.CloseOldDbs(closeTime); cerr != nil {
+			f.logger.Error("CloseOldDbs failed", "err", cerr)
+			f.error(errCloseDB, cerr)
+			return cerr
+		}
+		if cerr := f.closeOldLateDbs(line.Time); cerr != nil {
+			f.logger.Error("closeOldLateDbs failed", "err", cerr)
+			f.error(errCloseDB, cerr)
 			return cerr
 		}
+		f.stats.openDBs.Set(float64(f.rec.Len()))
+		f.status.openRecs(f.rec.Files())
+		f.stats.position(f.Pos.Name, f.Pos.Offset)
 	case tail.NewFileNotify:
-		f.lastTime = line.Time
+		f.stats.opens.Inc()
+		if f.opened {
+			f.stats.reopens.Inc()
+			if line.Filename == f.Pos.Name { // 同じパスを開き直した
+				f.stats.rotations.Inc()
+			}
+		}
+		f.opened = true
+		if f.timeEx == nil { // EventTime使用時は直前の行のイベント時刻を引き継ぐ
+			f.lastTime = line.Time
+		}
 		f.Pos.Name = line.Filename
 		f.Pos.CreateAt = line.OpenTime
+		f.Pos.Info = line.FileInfo
 		f.Pos.Offset = line.Offset
 		maxsize := line.Offset
 		if f.MaxHeadHashSize < line.Offset {
@@ -239,10 +473,11 @@ func (f *Ftail) lineNotifyAction(ctx context.Context, line *tail.Line, workerLim
 		}
 		f.Pos.HeadHash, f.Pos.HashLength, err = f.getHeadHash(f.Pos.Name, maxsize)
 		if err != nil {
-			log.Printf("getHeadHash err:%s", err)
+			f.logger.Error("getHeadHash failed", "file", f.Pos.Name, "err", err)
+			f.error(errHeadHash, err)
 			return err
 		}
-		log.Printf("NewFileNotify getHeadHash :%s", f.Pos)
+		f.logger.Info("new file", "file", f.Pos.Name, "offset", f.Pos.Offset, "pos", f.Pos)
 	}
 	return nil
 }
@@ -264,17 +499,65 @@ func (f *Ftail) addHash(line []byte) error {
 }
 
 func (f *Ftail) Write(line *tail.Line) (err error) {
-	f.lastTime = line.Time
+	text, ok := f.filters.apply(line.Text)
+	t := line.Time
+	f.reading = true
+	if f.timeEx != nil {
+		if !ok && !f.lastTime.IsZero() { // 捨てた行で期間は変えない
+			t = f.lastTime
+		} else {
+			t = f.eventTime(text, line.Time)
+		}
+		// 期間が変わる場合はPositionを進める前に書き出す
+		slice := tailex.Truncate(t, f.Period)
+		if f.buf.Len() > 0 && !slice.Equal(f.bufSlice) {
+			if err = f.Flush(); err != nil {
+				return err
+			}
+		}
+		f.bufSlice = slice
+	}
+	f.lastTime = t
 	f.Pos.Name = line.Filename
 	f.Pos.CreateAt = line.OpenTime
 	f.Pos.Offset = line.Offset
 	if f.Pos.HashLength < f.MaxHeadHashSize {
-		if err := f.addHash(line.Text); err != nil {
+		raw := line.Text
+		if line.Raw != nil { // HeadHashは変換前のファイルの内容で計算する
+			raw = line.Raw
+		}
+		if err := f.addHash(raw); err != nil {
 			return err
 		}
 	}
-	_, err = f.Writer.Write(line.Text)
-	return err
+	if !ok {
+		f.pending = true
+		return nil
+	}
+	if _, err = f.Writer.Write(text); err != nil {
+		return err
+	}
+	if f.Parser != nil {
+		return f.parse(text)
+	}
+	return nil
+}
+
+// parse パース結果を1行1JSONでrecBufに追加する。パースできない行はnull
+func (f *Ftail) parse(text []byte) error {
+	if f.recEnc == nil {
+		f.recEnc = json.NewEncoder(&f.recBuf)
+	}
+	rec, err := f.Parser.Parse(text)
+	if err != nil {
+		rec = nil
+	}
+	return f.recEnc.Encode(rec)
+}
+
+// FilterCounts Filter毎の適用行数
+func (f *Ftail) FilterCounts() []FilterCount {
+	return f.filters.counts()
 }
 
 type nopCloser struct{ io.Writer }
@@ -287,9 +570,11 @@ func NopCloser(w io.Writer) io.WriteCloser {
 	return nopCloser{w}
 }
 func (f *Ftail) Flush() error {
-	if f.buf.Len() <= 0 {
+	if f.buf.Len() <= 0 && !f.pending {
 		return nil
 	}
+	start := time.Now()
+	size := f.buf.Len()
 	var b bytes.Buffer
 	w, err := zlib.NewWriterLevel(&b, zlib.BestCompression)
 	if err != nil {
@@ -299,7 +584,7 @@ func (f *Ftail) Flush() error {
 	row.Text = f.buf.String()
 	_, err = io.Copy(w, &f.buf)
 	if cerr := w.Close(); cerr != nil {
-		log.Printf("zlib close err:%s", cerr)
+		f.logger.Error("zlib close failed", "err", cerr)
 	}
 	if err != nil {
 		return err
@@ -308,12 +593,37 @@ func (f *Ftail) Flush() error {
 		row.Bin = b.Bytes()
 		row.Text = ""
 	}
+	if f.recBuf.Len() > 0 {
+		if row.Recs, err = compress(&f.recBuf); err != nil {
+			return err
+		}
+	}
 	//log.Printf("text:'%s',bin:'%x', buf.String:%s", row.Text, row.Bin, f.buf.String())
 	defer f.buf.Reset()
-	if err = f.rec.Put(row); err != nil {
-		log.Printf("Flush %s err:%s", f.Pos.Name, err)
+	if err = f.put(row); err != nil {
+		f.logger.Error("flush failed", "file", f.Pos.Name, "offset", f.Pos.Offset, "period", row.Time.Truncate(f.Period), "err", err)
+		f.error(errFlush, err)
+		return err
+	}
+	f.pending = false
+	f.stats.bytesFlushed.Add(uint64(size))
+	f.stats.bytesWritten.Add(uint64(len(row.Bin) + len(row.Text) + len(row.Recs)))
+	f.stats.flushLatency.Observe(time.Since(start).Seconds())
+	f.status.flushed()
+	return nil
+}
+
+func compress(buf *bytes.Buffer) ([]byte, error) {
+	var b bytes.Buffer
+	w, err := zlib.NewWriterLevel(&b, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(w, buf)
+	if cerr := w.Close(); cerr != nil && err == nil {
+		err = cerr
 	}
-	return err
+	return b.Bytes(), err
 }
 
 func (f *Ftail) getHeadHash(fname string, getLength int64) (hash string, length int64, err error) {
@@ -329,7 +639,7 @@ func (f *Ftail) getHeadHash(fname string, getLength int64) (hash string, length
 	}
 	defer func() {
 		if err := readFile.Close(); err != nil {
-			log.Printf("readFile.Close err:%s", err)
+			f.logger.Warn("close failed", "file", fname, "err", err)
 		}
 	}()
 	tee := io.TeeReader(io.LimitReader(readFile, getLength), f.headHash)
@@ -0,0 +1,221 @@
+package ftail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/masahide/ftailer/core"
+	"github.com/masahide/ftailer/in/journal"
+	"github.com/masahide/ftailer/in/syslog"
+	"github.com/masahide/ftailer/tail"
+)
+
+// Input 読み込み元の種類
+type Input string
+
+const (
+	InputFile      Input = ""           // Path, PathFmtのファイル
+	InputStdin     Input = "stdin"      // 標準入力 (EOFで終了)
+	InputFIFO      Input = "fifo"       // Pathの名前付きパイプ (EOFで開き直す)
+	InputCommand   Input = "command"    // Commandの標準出力と標準エラー出力 (終了すると再起動する)
+	InputSyslogUDP Input = "syslog-udp" // ListenでUDPのsyslog (RFC3164, RFC5424) を受信する
+	InputSyslogTCP Input = "syslog-tcp" // ListenでTCPのsyslogを受信する (RFC6587のoctet-countingと改行区切り)
+	InputJournal   Input = "journal"    // Pathのファイル、またはListen (unix:/path, host:port) で受信したsystemd journal export format
+)
+
+var errStreamStart = errors.New("Start is only supported for file inputs")
+
+// stream シーク出来ない入力。Pos.Offsetは読み込んだバイト数の累計
+func (c Config) stream() bool {
+	return c.Input != InputFile
+}
+
+// streamName Pos.Name, Line.Filenameに使う入力の名前
+func (c Config) streamName() string {
+	switch c.Input {
+	case InputFIFO:
+		return c.Path
+	case InputCommand:
+		return "command:" + strings.Join(c.Command, " ")
+	case InputSyslogUDP, InputSyslogTCP:
+		return string(c.Input) + ":" + c.Listen
+	case InputJournal:
+		if c.Listen == "" {
+			return c.Path
+		}
+		return string(c.Input) + ":" + c.Listen
+	}
+	return string(c.Input)
+}
+
+// opener Inputに対応するtail.Opener。offsetは保存済みのPos.Offset
+func (c Config) opener(logger *slog.Logger, offset int64) (tail.Opener, error) {
+	switch c.Input {
+	case InputStdin:
+		return tail.Stdin(), nil
+	case InputFIFO:
+		if c.Path == "" {
+			return nil, fmt.Errorf("input %s: Path is empty", c.Input)
+		}
+		return tail.FIFO(c.Path), nil
+	case InputCommand:
+		if len(c.Command) == 0 {
+			return nil, fmt.Errorf("input %s: Command is empty", c.Input)
+		}
+		return tail.Command(c.Command), nil
+	case InputSyslogUDP, InputSyslogTCP:
+		if c.Listen == "" {
+			return nil, fmt.Errorf("input %s: Listen is empty", c.Input)
+		}
+		network := strings.TrimPrefix(string(c.Input), "syslog-")
+		return messageOpener(func(ctx context.Context, emit func(any)) error {
+			s, err := syslog.Listen(network, c.Listen)
+			if err != nil {
+				return err
+			}
+			s.Logger = logger
+			logger.Info("listen", "network", network, "addr", s.Addr())
+			return s.Serve(ctx, func(m *syslog.Message) { emit(m) })
+		}), nil
+	case InputJournal:
+		if c.Listen == "" && c.Path == "" {
+			return nil, fmt.Errorf("input %s: Path or Listen is empty", c.Input)
+		}
+		return journalOpener(c, logger, offset), nil
+	}
+	return nil, fmt.Errorf("unknown input %q", c.Input)
+}
+
+// streamPosition 保存済みのポジションが同じ入力のものであればバイト数の累計を引き継ぐ
+func (f *Ftail) streamPosition(c Config) *core.Position {
+	name := c.streamName()
+	if f.Pos != nil && f.Pos.Name == name {
+		return f.Pos
+	}
+	return &core.Position{Name: name}
+}
+
+// startStream 入力を読み込むtail.Streamを開始する
+func (f *Ftail) startStream(ctx context.Context, c Config) (chan *tail.Line, error) {
+	open, err := c.opener(f.logger, f.Pos.Offset)
+	if err != nil {
+		return nil, err
+	}
+	s, err := tail.NewStream(ctx, c.streamName(), open, f.Config.Config.Config, f.Pos.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return s.Lines, nil
+}
+
+// journalOpener ファイルは1度だけ読み込み、Listenは接続を待ち受ける
+// エントリは__REALTIME_TIMESTAMPをtimeに加えたJSONの行になる
+// ファイルは先頭から読み直し、JSONの行の累計がoffsetまでのエントリは読み込み済みとして読み飛ばす
+func journalOpener(c Config, logger *slog.Logger, offset int64) tail.Opener {
+	emitEntry := func(emit func(any)) func(journal.Entry) {
+		return func(e journal.Entry) {
+			m := make(map[string]string, len(e)+1)
+			for k, v := range e {
+				m[k] = v
+			}
+			if t, ok := e.Time(); ok {
+				m["time"] = t.Format(time.RFC3339Nano)
+			}
+			emit(m)
+		}
+	}
+	if c.Listen == "" {
+		return messageOpener(func(ctx context.Context, emit func(any)) error {
+			file, err := os.Open(c.Path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			if err := journal.Read(file, emitEntry(skipBytes(offset, emit))); err != nil {
+				return err
+			}
+			return io.EOF
+		})
+	}
+	return messageOpener(func(ctx context.Context, emit func(any)) error {
+		network, addr := "tcp", c.Listen
+		if path, ok := strings.CutPrefix(c.Listen, "unix:"); ok {
+			network, addr = "unix", path
+		}
+		ln, err := net.Listen(network, addr)
+		if err != nil {
+			return err
+		}
+		logger.Info("listen", "network", network, "addr", ln.Addr())
+		return journal.Serve(ctx, ln, emitEntry(emit), logger)
+	})
+}
+
+// skipBytes JSONの行の累計がskipまでのメッセージをemitしない
+func skipBytes(skip int64, emit func(any)) func(any) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	return func(v any) {
+		if skip > 0 {
+			b.Reset()
+			if err := enc.Encode(v); err == nil && int64(b.Len()) <= skip {
+				skip -= int64(b.Len())
+				return
+			}
+			skip = 0
+		}
+		emit(v)
+	}
+}
+
+// messageOpener serveがemitしたメッセージをJSONの1行としてtail.Streamに読み込ませる
+// serveがio.EOFを返した場合は入力の終わり
+func messageOpener(serve func(ctx context.Context, emit func(any)) error) tail.Opener {
+	done := false
+	return func(ctx context.Context) (io.ReadCloser, error) {
+		if done {
+			return nil, io.EOF
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		pr, pw := io.Pipe()
+		var mu sync.Mutex
+		enc := json.NewEncoder(pw)
+		enc.SetEscapeHTML(false)
+		emit := func(v any) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err := enc.Encode(v); err != nil { // 読み込み側が閉じられた
+				cancel()
+			}
+		}
+		go func() {
+			err := serve(ctx, emit)
+			if err == io.EOF {
+				done, err = true, nil
+			}
+			pw.CloseWithError(err)
+		}()
+		return &messageReader{PipeReader: pr, cancel: cancel}, nil
+	}
+}
+
+type messageReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (r *messageReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
@@ -0,0 +1,39 @@
+package ftail
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJournalResume(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "journal.export")
+	os.WriteFile(name, []byte("MESSAGE=a\n\nMESSAGE=b\n\n"), 0644)
+	c := Config{Input: InputJournal}
+	c.Path = name
+	read := func(offset int64) string {
+		r, err := journalOpener(c, slog.Default(), offset)(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	all := read(0)
+	if all != "{\"MESSAGE\":\"a\"}\n{\"MESSAGE\":\"b\"}\n" {
+		t.Fatalf("got %q", all)
+	}
+	// 読み込み済みのエントリは読み飛ばす
+	first := strings.Index(all, "\n") + 1
+	if got := read(int64(first)); got != all[first:] {
+		t.Errorf("offset %d: got %q, want %q", first, got, all[first:])
+	}
+}
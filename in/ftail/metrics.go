@@ -0,0 +1,85 @@
+package ftail
+
+import (
+	"os"
+	"time"
+
+	"github.com/masahide/ftailer/metrics"
+)
+
+// エラーの種類 (ftailer_errors_total の type ラベル)
+const (
+	errRead     = "read"      // 行の読み込み・文字コード変換
+	errFlush    = "flush"     // DBへの書き込み
+	errCreateDB = "create_db" // 新しい期間のDBの作成
+	errCloseDB  = "close_db"  // 古い期間のDBを閉じる
+	errHeadHash = "head_hash" // ファイル先頭のハッシュ計算
+)
+
+// sourceMetrics ソース毎のメトリクス
+type sourceMetrics struct {
+	registry     *metrics.Registry
+	labels       metrics.Labels
+	lines        *metrics.Counter
+	bytesRead    *metrics.Counter
+	bytesFlushed *metrics.Counter // Flushした展開後のバイト数
+	bytesWritten *metrics.Counter // DBに書き込んだ(圧縮後の)バイト数
+	flushLatency *metrics.Histogram
+	offset       *metrics.Gauge
+	fileSize     *metrics.Gauge
+	lag          *metrics.Gauge
+	openDBs      *metrics.Gauge
+	opens        *metrics.Counter
+	reopens      *metrics.Counter
+	rotations    *metrics.Counter
+	workerWait   *metrics.Counter
+}
+
+func newSourceMetrics(r *metrics.Registry, name string) *sourceMetrics {
+	l := metrics.Labels{"source": name}
+	return &sourceMetrics{
+		registry:     r,
+		labels:       l,
+		lines:        r.Counter("ftailer_lines_read_total", "Lines read from the source file.", l),
+		bytesRead:    r.Counter("ftailer_bytes_read_total", "Bytes read from the source file.", l),
+		bytesFlushed: r.Counter("ftailer_bytes_flushed_total", "Uncompressed bytes written by Flush.", l),
+		bytesWritten: r.Counter("ftailer_bytes_written_total", "Bytes written to DB files by Flush after compression.", l),
+		flushLatency: r.Histogram("ftailer_flush_duration_seconds", "Latency of Flush.", l, nil),
+		offset:       r.Gauge("ftailer_position_offset_bytes", "Current Position.Offset.", l),
+		fileSize:     r.Gauge("ftailer_file_size_bytes", "Size of the file being tailed.", l),
+		lag:          r.Gauge("ftailer_lag_bytes", "File size minus Position.Offset.", l),
+		openDBs:      r.Gauge("ftailer_open_dbs", "Number of open DBs in the DBpool.", l),
+		opens:        r.Counter("ftailer_file_opens_total", "Files opened by tail.", l),
+		reopens:      r.Counter("ftailer_file_reopens_total", "Files reopened after the first open.", l),
+		rotations:    r.Counter("ftailer_rotations_total", "Rotations of the same path detected by watch.", l),
+		workerWait:   r.Counter("ftailer_worker_wait_microseconds_total", "Time spent waiting for workerLimit.", l),
+	}
+}
+
+func (m *sourceMetrics) error(typ string) {
+	m.registry.Counter("ftailer_errors_total", "Errors by type.", m.labels.With("type", typ)).Inc()
+}
+
+// position 現在のPositionとファイルサイズの差
+func (m *sourceMetrics) position(name string, offset int64) {
+	m.offset.Set(float64(offset))
+	if name == "" {
+		return
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return
+	}
+	m.fileSize.Set(float64(fi.Size()))
+	m.lag.Set(float64(fi.Size() - offset))
+}
+
+func (m *sourceMetrics) waited(start time.Time) {
+	m.workerWait.Add(uint64(time.Since(start) / time.Microsecond))
+}
+
+// workerLimitMetrics workerLimitの使用数と容量
+func workerLimitMetrics(r *metrics.Registry, workerLimit chan bool) {
+	r.GaugeFunc("ftailer_worker_limit_in_use", "Workers holding workerLimit.", nil, func() float64 { return float64(len(workerLimit)) })
+	r.GaugeFunc("ftailer_worker_limit_capacity", "Capacity of workerLimit.", nil, func() float64 { return float64(cap(workerLimit)) })
+}
@@ -0,0 +1,190 @@
+package ftail
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/masahide/ftailer/core"
+	"github.com/masahide/ftailer/tail"
+	"github.com/masahide/ftailer/tailex"
+)
+
+// ReplayResult Replayの結果
+type ReplayResult struct {
+	Lines   int // 読み込んだ行数
+	Rows    int // 書き込んだ行 (Flush) の数
+	Skipped int // 既存のDBファイルがある期間のため書き込まなかった行 (Flush) の数
+}
+
+// replayGuard 稼働中のRecorderと衝突しないように書き込み先の期間を確認する
+// 既存のDBファイル(.rec, .fixed, .arc)がある期間と、最新のDBファイルの期間以降には書き込まない
+type replayGuard struct {
+	busy    map[time.Time]bool
+	arcs    [][2]time.Time // アーカイブの期間 [開始, 終了)
+	horizon time.Time      // 既存の最新のDBファイルの期間
+	result  ReplayResult
+}
+
+// errReplayNoDB 最新の期間を決められないため、DBファイルのないBufDirにはReplayしない
+// (Replayしたファイルのポジションが次回のStartで読み込まれてしまう)
+var errReplayNoDB = errors.New("replay: no DB files in BufDir for Name. start ftailer first")
+
+func newReplayGuard(db *core.DB, period time.Duration) (*replayGuard, error) {
+	g := &replayGuard{busy: map[time.Time]bool{}}
+	for _, glob := range []func(*core.DB) ([]core.DBFiles, error){core.RecGlob, core.FixGlob} {
+		files, err := glob(db)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			t := tailex.Truncate(f.Time, period)
+			g.busy[t] = true
+			if t.After(g.horizon) {
+				g.horizon = t
+			}
+		}
+	}
+	arcs, err := core.ArcGlob(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range arcs {
+		a, err := core.OpenArchive(f.Path)
+		if err != nil {
+			return nil, err
+		}
+		a.Close()
+		g.arcs = append(g.arcs, [2]time.Time{f.Time, f.Time.Add(a.Period)})
+		if f.Time.After(g.horizon) {
+			g.horizon = f.Time
+		}
+	}
+	if g.horizon.IsZero() {
+		return nil, errReplayNoDB
+	}
+	return g, nil
+}
+
+func (g *replayGuard) allowed(t time.Time) bool {
+	if g.busy[t] {
+		return false
+	}
+	if !t.Before(g.horizon) {
+		return false
+	}
+	for _, a := range g.arcs {
+		if !t.Before(a[0]) && t.Before(a[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// put 行の時刻の期間のDBに書き込む
+// 既に閉じた期間の行は LateOriginal と同じく現在の期間に書き込む
+func (g *replayGuard) put(rec *core.Recorder, row core.Row) error {
+	if !g.allowed(row.Time.Truncate(rec.Period)) {
+		g.result.Skipped++
+		return nil
+	}
+	err := rec.PutPast(row)
+	if err == core.ErrTimePast { // Replay中に閉じた期間
+		err = rec.PutIn(rec.InTime(), row)
+	}
+	if err == nil {
+		g.result.Rows++
+	}
+	return err
+}
+
+// Replay ファイル(gzip圧縮も可)を先頭から読み込み、Startと同じWrite/Flushで BufDir/Name に書き込む
+// 行の時刻はEventTimeで取り出した時刻、指定がない場合や取り出せない場合はファイルの更新時刻
+// 稼働中のftailerの.recファイルは開かず、既存のDBファイルがない過去の期間だけに書き込む
+// DBファイルが1つもない場合はerrReplayNoDB
+func Replay(ctx context.Context, c Config, path string) (*ReplayResult, error) {
+	f, err := newFtail(c)
+	if err != nil {
+		return nil, err
+	}
+	if f.replay, err = newReplayGuard(&core.DB{Path: c.BufDir, Name: c.Name}, c.Period); err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	f.rec = core.NewReplayRecorder(c.BufDir, c.Name, c.Period, c.Logger)
+	f.rec.Meta = tags(c.Tags)
+	f.Pos = &core.Position{Name: path, CreateAt: fi.ModTime()}
+	f.Writer = NopCloser(&f.buf)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r, err := replayReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	err = f.replayLines(ctx, r, path, fi.ModTime())
+	if ferr := f.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := f.rec.AllFix(); err == nil {
+		err = cerr
+	}
+	for _, fc := range f.filters.counts() {
+		f.logger.Info("filter count", "filter", fc.Name, "matched", fc.Matched, "dropped", fc.Dropped)
+	}
+	return &f.replay.result, err
+}
+
+// replayReader gzip圧縮されている場合は展開する
+func replayReader(file *os.File) (*bufio.Reader, error) {
+	br := bufio.NewReader(file)
+	magic, err := br.Peek(2)
+	if err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return br, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	return bufio.NewReader(zr), nil
+}
+
+func (f *Ftail) replayLines(ctx context.Context, r *bufio.Reader, path string, mtime time.Time) error {
+	offset := int64(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		text, err := r.ReadBytes('\n')
+		if len(text) > 0 {
+			offset += int64(len(text))
+			line := &tail.Line{Time: mtime, Text: text, Filename: path, Offset: offset, OpenTime: mtime, NotifyType: tail.NewLineNotify}
+			if werr := f.Write(line); werr != nil {
+				return werr
+			}
+			f.replay.result.Lines++
+			if f.buf.Len() >= f.MaxBufSize {
+				if ferr := f.Flush(); ferr != nil {
+					return ferr
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package ftail
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/masahide/ftailer/core"
+	"github.com/masahide/ftailer/parser"
+)
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := Config{Name: "name", BufDir: dir, Period: time.Minute, EventTime: &parser.TimeExtractor{Layout: time.RFC3339}}
+	if _, err := Replay(context.Background(), c, filepath.Join(dir, "none.log")); err != errReplayNoDB {
+		t.Errorf("Replay to empty BufDir => %v, want %v", err, errReplayNoDB)
+	}
+	// 稼働中のftailerが書き込んでいる期間
+	live := core.NewReplayRecorder(dir, "name", time.Minute, nil)
+	liveTime := time.Date(2015, 7, 1, 10, 3, 0, 0, time.UTC).Local()
+	if err := live.Put(core.Row{Time: liveTime, Pos: &core.Position{Name: "live.log"}, Text: "live\n"}); err != nil {
+		t.Fatal(err)
+	}
+	defer live.AllClose()
+
+	path := filepath.Join(dir, "old.log.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	zw.Write([]byte("2015-07-01T10:00:10Z a\n2015-07-01T10:01:10Z b\n2015-07-01T10:00:20Z c\n2015-07-01T10:03:10Z d\n"))
+	zw.Close()
+	f.Close()
+
+	res, err := Replay(context.Background(), c, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Lines != 4 || res.Rows != 3 || res.Skipped != 1 {
+		t.Errorf("Replay => %+v", res)
+	}
+	fixed, err := core.FixGlob(&core.DB{Path: dir, Name: "name"})
+	if err != nil || len(fixed) != 2 {
+		t.Fatalf("FixGlob => %v, %v", fixed, err)
+	}
+	text := ""
+	for _, fx := range fixed {
+		db, err := core.FtailDBOpen(fx.Path, 0644, &core.FtailDBOptions{ReadOnly: true, Bin: true}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.ReadRows(func(row *core.Row) error {
+			text += row.Text
+			return nil
+		})
+		db.Close()
+	}
+	if text != "2015-07-01T10:00:10Z a\n2015-07-01T10:01:10Z b\n2015-07-01T10:00:20Z c\n" {
+		t.Errorf("replayed text => %q", text)
+	}
+}
@@ -0,0 +1,208 @@
+package ftail
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/masahide/ftailer/parser"
+)
+
+// StartKind 読み込み開始位置の種類
+type StartKind string
+
+const (
+	StartBeginning StartKind = "beginning" // ファイルの先頭
+	StartEnd       StartKind = "end"       // ファイルの末尾
+	StartOffset    StartKind = "offset"    // 先頭からのバイト数
+	StartLines     StartKind = "lines"     // 末尾からの行数
+	StartTime      StartKind = "time"      // イベント時刻がTime以降の最初の行 (EventTimeが必要)
+)
+
+// StartMode Config.Startを解析した読み込み開始位置
+type StartMode struct {
+	Kind StartKind
+	N    int64     // offset, lines
+	Time time.Time // time
+}
+
+// ParseStart beginning, end, offset:N, lines:N, time:T (RFC3339) を解析する
+func ParseStart(s string) (StartMode, error) {
+	kind, arg, hasArg := strings.Cut(s, ":")
+	m := StartMode{Kind: StartKind(kind)}
+	switch m.Kind {
+	case StartBeginning, StartEnd:
+		if hasArg {
+			return m, fmt.Errorf("start %q: %s takes no argument", s, kind)
+		}
+		return m, nil
+	case StartOffset, StartLines:
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || n < 0 {
+			return m, fmt.Errorf("start %q: %s needs a non-negative number", s, kind)
+		}
+		m.N = n
+		return m, nil
+	case StartTime:
+		t, err := time.Parse(time.RFC3339, arg)
+		if err != nil {
+			return m, fmt.Errorf("start %q: %s", s, err)
+		}
+		m.Time = t
+		return m, nil
+	}
+	return m, fmt.Errorf("start %q: unknown mode (beginning, end, offset:N, lines:N or time:T)", s)
+}
+
+var (
+	errStartTime  = errors.New("start time: EventTime is not configured")
+	errForceStart = errors.New("ForceStart needs Start")
+)
+
+// startOffset pathのmの位置のオフセット
+func startOffset(path string, m StartMode, timeEx *parser.TimeExtractor) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := fi.Size()
+	switch m.Kind {
+	case StartEnd:
+		return size, nil
+	case StartOffset:
+		if m.N > size {
+			return size, nil
+		}
+		return m.N, nil
+	case StartLines:
+		return linesBack(file, size, m.N)
+	case StartTime:
+		if timeEx == nil {
+			return 0, errStartTime
+		}
+		return searchTime(file, size, m.Time, timeEx)
+	}
+	return 0, nil
+}
+
+// linesBack 末尾からn行目の行頭のオフセット
+func linesBack(r io.ReaderAt, size, n int64) (int64, error) {
+	if n == 0 {
+		return size, nil
+	}
+	const chunk = 64 * 1024
+	buf := make([]byte, chunk)
+	end := size
+	var count int64
+	for end > 0 {
+		start := end - chunk
+		if start < 0 {
+			start = 0
+		}
+		b := buf[:end-start]
+		if _, err := r.ReadAt(b, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := len(b) - 1; i >= 0; i-- {
+			if b[i] != '\n' || start+int64(i) == size-1 { // 最終行の改行は数えない
+				continue
+			}
+			if count++; count == n {
+				return start + int64(i) + 1, nil
+			}
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// searchTime イベント時刻がt以降の最初の行のオフセットを二分探索する
+// 時刻を取り出せない行(複数行のログの続きなど)は次の時刻のある行の時刻として扱う
+func searchTime(r io.ReaderAt, size int64, t time.Time, timeEx *parser.TimeExtractor) (int64, error) {
+	lo, hi := int64(0), size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		lt, _, ok, err := timedLineAt(r, size, mid, timeEx)
+		if err != nil {
+			return 0, err
+		}
+		if !ok || !lt.Before(t) {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	_, start, ok, err := timedLineAt(r, size, lo, timeEx)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		return start, nil
+	}
+	return partialLineStart(r, size, t, timeEx)
+}
+
+// partialLineStart 書き込み途中の最終行の行頭のオフセット
+// 最終行が改行で終わっている場合や、最終行の時刻がtより前の場合はsize
+func partialLineStart(r io.ReaderAt, size int64, t time.Time, timeEx *parser.TimeExtractor) (int64, error) {
+	if size == 0 {
+		return size, nil
+	}
+	start, err := linesBack(r, size, 1)
+	if err != nil {
+		return 0, err
+	}
+	line := make([]byte, size-start)
+	if _, err := r.ReadAt(line, start); err != nil && err != io.EOF {
+		return 0, err
+	}
+	if line[len(line)-1] == '\n' {
+		return size, nil
+	}
+	if lt, err := timeEx.Extract(line); err == nil && lt.Before(t) {
+		return size, nil
+	}
+	return start, nil
+}
+
+// timedLineAt offの位置以降に始まる最初の時刻のある行の時刻と行頭のオフセット
+func timedLineAt(r io.ReaderAt, size, off int64, timeEx *parser.TimeExtractor) (time.Time, int64, bool, error) {
+	pos := off
+	if off > 0 { // 行の途中の場合は次の行から
+		pos = off - 1
+	}
+	br := bufio.NewReader(io.NewSectionReader(r, pos, size-pos))
+	if off > 0 {
+		skip, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return time.Time{}, 0, false, nil
+		} else if err != nil {
+			return time.Time{}, 0, false, err
+		}
+		pos += int64(len(skip))
+	}
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 && err == nil { // 改行のない最終行は書き込み途中
+			if lt, xerr := timeEx.Extract(line); xerr == nil {
+				return lt, pos, true, nil
+			}
+		}
+		if err == io.EOF {
+			return time.Time{}, 0, false, nil
+		} else if err != nil {
+			return time.Time{}, 0, false, err
+		}
+		pos += int64(len(line))
+	}
+}
@@ -0,0 +1,131 @@
+package ftail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/masahide/ftailer/parser"
+	"github.com/masahide/ftailer/tail"
+)
+
+func TestParseStart(t *testing.T) {
+	for _, e := range []struct {
+		in   string
+		want StartMode
+		err  bool
+	}{
+		{"beginning", StartMode{Kind: StartBeginning}, false},
+		{"end", StartMode{Kind: StartEnd}, false},
+		{"offset:100", StartMode{Kind: StartOffset, N: 100}, false},
+		{"lines:10", StartMode{Kind: StartLines, N: 10}, false},
+		{"time:2026-10-17T10:00:00Z", StartMode{Kind: StartTime, Time: time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)}, false},
+		{"end:1", StartMode{}, true},
+		{"lines:-1", StartMode{}, true},
+		{"time:yesterday", StartMode{}, true},
+		{"middle", StartMode{}, true},
+	} {
+		m, err := ParseStart(e.in)
+		if (err != nil) != e.err {
+			t.Errorf("ParseStart(%q) err=%v", e.in, err)
+			continue
+		}
+		if err == nil && (m.Kind != e.want.Kind || m.N != e.want.N || !m.Time.Equal(e.want.Time)) {
+			t.Errorf("ParseStart(%q)=%+v, want %+v", e.in, m, e.want)
+		}
+	}
+}
+
+func TestStartOffset(t *testing.T) {
+	// 10:00:00から10秒毎の行と時刻のない続きの行。最終行は書き込み途中
+	content := "2026-10-17T10:00:00Z a\n" + // 0
+		"2026-10-17T10:00:10Z b\n" + // 23
+		"  continued\n" + // 46
+		"2026-10-17T10:00:20Z c\n" + // 58
+		"2026-10-17T10:00:30Z d\n" + // 81
+		"2026-10-17T10:00:40Z e" // 104
+	name := filepath.Join(t.TempDir(), "a.log")
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	timeEx, err := parser.NewTimeExtractor(parser.TimeExtractor{Layout: time.RFC3339})
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(content))
+	for _, e := range []struct {
+		start string
+		want  int64
+	}{
+		{"beginning", 0},
+		{"end", size},
+		{"offset:30", 30},
+		{"offset:1000", size},
+		{"lines:0", size},
+		{"lines:1", 104},
+		{"lines:2", 81},
+		{"lines:4", 46},
+		{"lines:100", 0},
+		{"time:2026-10-17T09:00:00Z", 0},
+		{"time:2026-10-17T10:00:00Z", 0},
+		{"time:2026-10-17T10:00:05Z", 23},
+		{"time:2026-10-17T10:00:15Z", 58},
+		{"time:2026-10-17T10:00:30Z", 81},
+		{"time:2026-10-17T10:00:35Z", 104},
+		{"time:2026-10-17T11:00:00Z", size},
+	} {
+		m, err := ParseStart(e.start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		off, err := startOffset(name, m, timeEx)
+		if err != nil || off != e.want {
+			t.Errorf("startOffset(%s)=%d, %v, want %d", e.start, off, err, e.want)
+		}
+	}
+	if _, err := startOffset(name, StartMode{Kind: StartTime}, nil); err != errStartTime {
+		t.Errorf("startOffset without EventTime err=%v", err)
+	}
+}
+
+func TestStartSymlink(t *testing.T) {
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	target, link := filepath.Join(dir, "a.log"), filepath.Join(dir, "current.log")
+	os.WriteFile(target, []byte(strings.Repeat("0123456789abcdefghi\n", 10)), 0644)
+	os.Symlink(target, link)
+	c := Config{Name: "name", BufDir: dir, Period: time.Minute, Start: "end"}
+	c.Path = link
+	c.FollowSymlink = true
+	f, err := newFtail(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Config.Config.Config = tailConfig(c.Config.Config)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	lines, err := f.startFile(ctx, f.Config, make(chan bool, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 保存済みのポジションがない場合はリンク先をStartの位置から読む
+	if f.Pos.Name != target || f.Pos.Offset != 200 {
+		t.Errorf("Pos => %+v, want %s offset 200", f.Pos, target)
+	}
+	for {
+		select {
+		case l := <-lines:
+			if l.NotifyType != tail.NewFileNotify {
+				continue
+			}
+			if l.Offset != 200 {
+				t.Errorf("NewFileNotify offset => %d, want 200", l.Offset)
+			}
+			return
+		case <-ctx.Done():
+			t.Fatal("timeout")
+		}
+	}
+}
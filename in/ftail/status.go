@@ -0,0 +1,238 @@
+package ftail
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/masahide/ftailer/core"
+	"github.com/masahide/ftailer/tail"
+	"github.com/masahide/ftailer/tailex"
+)
+
+// State ソースの状態
+type State string
+
+const (
+	StateStarting State = "starting" // Start直後
+	StateWaiting  State = "waiting"  // GlobSearchLoopでファイルを待っている
+	StateTailing  State = "tailing"  // ファイルを読み込んでいる
+	StatePaused   State = "paused"   // workerLimitの空きを待っている
+	StateErrored  State = "errored"  // エラーで終了した
+	StateStopped  State = "stopped"  // キャンセルで終了した
+)
+
+// Status ソースの状態 (/status で出力)
+type Status struct {
+	Name          string         `json:"name"`
+	State         State          `json:"state"`
+	Since         time.Time      `json:"since"` // Stateになった時刻
+	Path          string         `json:"path,omitempty"`
+	Inode         uint64         `json:"inode,omitempty"`
+	Position      *core.Position `json:"position,omitempty"`
+	LastActivity  time.Time      `json:"last_activity"` // 最後に行や通知を受け取った時刻
+	LastLine      time.Time      `json:"last_line,omitempty"`
+	LastFlush     time.Time      `json:"last_flush,omitempty"`
+	OpenRecs      []string       `json:"open_recs,omitempty"`
+	LastError     string         `json:"last_error,omitempty"`
+	LastErrorTime time.Time      `json:"last_error_time,omitempty"`
+}
+
+// sourceStatus Ftailのgoroutineから更新し、HTTPハンドラから読み込む
+type sourceStatus struct {
+	mu sync.Mutex
+	s  Status
+}
+
+var statuses = struct {
+	sync.Mutex
+	m map[string]*sourceStatus
+}{m: map[string]*sourceStatus{}}
+
+// newSourceStatus nameのStatusを登録する。同じnameの場合は置き換える
+func newSourceStatus(name string) *sourceStatus {
+	now := time.Now()
+	st := &sourceStatus{s: Status{Name: name, State: StateStarting, Since: now, LastActivity: now}}
+	statuses.Lock()
+	statuses.m[name] = st
+	statuses.Unlock()
+	return st
+}
+
+func (st *sourceStatus) update(fn func(s *Status)) {
+	st.mu.Lock()
+	fn(&st.s)
+	st.mu.Unlock()
+}
+
+func (st *sourceStatus) setState(state State) {
+	st.update(func(s *Status) {
+		if s.State != state {
+			s.State, s.Since = state, time.Now()
+		}
+	})
+}
+
+// notify 受け取った行・通知から状態を更新する
+func (st *sourceStatus) notify(line *tail.Line, pos *core.Position) {
+	st.update(func(s *Status) {
+		s.LastActivity = time.Now()
+		state := StateTailing
+		switch line.NotifyType {
+		case tailex.GlobLoopNotify:
+			state = StateWaiting
+		case tail.NewLineNotify:
+			s.LastLine = s.LastActivity
+		case tail.NewFileNotify:
+			s.Path = line.Filename
+			s.Inode, _ = core.FileInode(line.FileInfo)
+		}
+		if s.State != state {
+			s.State, s.Since = state, s.LastActivity
+		}
+		if pos != nil {
+			p := *pos
+			s.Position = &p
+		}
+	})
+}
+
+func (st *sourceStatus) flushed() {
+	st.update(func(s *Status) { s.LastFlush = time.Now() })
+}
+
+func (st *sourceStatus) openRecs(recs []string) {
+	st.update(func(s *Status) { s.OpenRecs = recs })
+}
+
+func (st *sourceStatus) error(err error) {
+	st.update(func(s *Status) {
+		s.LastError, s.LastErrorTime = err.Error(), time.Now()
+	})
+}
+
+// stopped Startの終了時の状態
+func (st *sourceStatus) stopped(err error) {
+	if err != nil && err != context.Canceled {
+		st.error(err)
+		st.setState(StateErrored)
+		return
+	}
+	st.setState(StateStopped)
+}
+
+func (st *sourceStatus) state() State {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.s.State
+}
+
+func (st *sourceStatus) get() Status {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s := st.s
+	s.OpenRecs = append([]string(nil), st.s.OpenRecs...)
+	return s
+}
+
+// Statuses 全てのソースのStatusを名前順に返す
+func Statuses() []Status {
+	statuses.Lock()
+	res := make([]Status, 0, len(statuses.m))
+	for _, st := range statuses.m {
+		res = append(res, st.get())
+	}
+	statuses.Unlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// stuck thresholdより長く行や通知がない、またはpausedのままのソース
+// TickerNotify, GlobLoopNotifyが定期的に届くため、止まっていなければLastActivityは更新される
+func stuck(s Status, now time.Time, threshold time.Duration) bool {
+	switch s.State {
+	case StateErrored:
+		return true
+	case StateStopped:
+		return false
+	case StatePaused:
+		return now.Sub(s.Since) > threshold
+	}
+	return now.Sub(s.LastActivity) > threshold
+}
+
+// Live 止まっているソースがあればエラー
+func Live(threshold time.Duration) error {
+	now := time.Now()
+	for _, s := range Statuses() {
+		if stuck(s, now, threshold) {
+			return fmt.Errorf("%s is %s since %s (last activity %s)", s.Name, s.State, s.Since.Format(time.RFC3339), s.LastActivity.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// Ready 全てのソースが開始していて止まっていなければnil
+func Ready(threshold time.Duration) error {
+	if err := Live(threshold); err != nil {
+		return err
+	}
+	for _, s := range Statuses() {
+		if s.State == StateStarting {
+			return fmt.Errorf("%s is %s", s.Name, s.State)
+		}
+	}
+	return nil
+}
+
+// StatusHandler 全てのソースのStatusをJSONで返すハンドラ
+func StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(Statuses())
+	})
+}
+
+// HealthHandler checkがエラーの場合は503を返すハンドラ (LiveやReadyを指定する)
+func HealthHandler(check func(time.Duration) error, threshold time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := check(threshold); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// acquire workerLimitを取得する。空きがない間はStatePaused
+// ctxがキャンセルされた場合はfalse
+func (f *Ftail) acquire(ctx context.Context, workerLimit chan bool) bool {
+	start := time.Now()
+	defer f.stats.waited(start)
+	select {
+	case workerLimit <- true:
+		return true
+	default:
+	}
+	prev := f.status.state()
+	f.status.setState(StatePaused)
+	defer f.status.setState(prev)
+	select {
+	case workerLimit <- true:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// error エラーをメトリクスとStatusに記録する
+func (f *Ftail) error(typ string, err error) {
+	f.stats.error(typ)
+	f.status.error(err)
+}
@@ -0,0 +1,44 @@
+package ftail
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/masahide/ftailer/core"
+	"github.com/masahide/ftailer/tail"
+	"github.com/masahide/ftailer/tailex"
+)
+
+func TestStatusStuck(t *testing.T) {
+	st := newSourceStatus("status_test")
+	defer func() {
+		statuses.Lock()
+		delete(statuses.m, "status_test")
+		statuses.Unlock()
+	}()
+	now := time.Now()
+	if s := st.get(); s.State != StateStarting || stuck(s, now, time.Minute) {
+		t.Errorf("new status => %+v", s)
+	}
+	st.notify(&tail.Line{NotifyType: tailex.GlobLoopNotify}, &core.Position{})
+	if s := st.get(); s.State != StateWaiting {
+		t.Errorf("GlobLoopNotify => %s", s.State)
+	}
+	st.notify(&tail.Line{NotifyType: tail.NewLineNotify}, &core.Position{Name: "a.log", Offset: 10})
+	s := st.get()
+	if s.State != StateTailing || s.Position.Offset != 10 || s.LastLine.IsZero() {
+		t.Errorf("NewLineNotify => %+v", s)
+	}
+	if stuck(s, now.Add(30*time.Second), time.Minute) || !stuck(s, now.Add(2*time.Minute), time.Minute) {
+		t.Errorf("stuck tailing => wrong")
+	}
+	st.setState(StatePaused)
+	if s := st.get(); !stuck(s, s.Since.Add(2*time.Minute), time.Minute) {
+		t.Errorf("stuck paused => false")
+	}
+	st.stopped(errors.New("disk full"))
+	if s := st.get(); s.State != StateErrored || s.LastError != "disk full" || Live(time.Minute) == nil {
+		t.Errorf("stopped => %+v", s)
+	}
+}
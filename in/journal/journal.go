@@ -0,0 +1,153 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Entry systemd journal export format の1エントリ (フィールド名と値)
+type Entry map[string]string
+
+// Time __REALTIME_TIMESTAMP (1970年からのマイクロ秒)
+func (e Entry) Time() (time.Time, bool) {
+	us, err := strconv.ParseInt(e["__REALTIME_TIMESTAMP"], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(us), true
+}
+
+// maxFieldSize 1フィールドの最大サイズ
+const maxFieldSize = 16 * 1024 * 1024
+
+var (
+	errField   = errors.New("journal: invalid field")
+	errTooLong = errors.New("journal: field too long")
+)
+
+// Reader journalctl -o export, systemd-journal-remote などの export format を読み込む
+type Reader struct {
+	br *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{br: bufio.NewReader(r)}
+}
+
+// Next 次のエントリ。エントリがない場合はio.EOF
+// テキストのフィールドは "NAME=value\n"、バイナリのフィールドは "NAME\n" + 64bit little endianの長さ + 値 + "\n"
+func (r *Reader) Next() (Entry, error) {
+	e := Entry{}
+	for {
+		line, err := r.readLine()
+		if err == io.EOF && len(e) > 0 && len(line) == 0 {
+			return e, nil
+		} else if err == io.EOF && len(line) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		line = line[:len(line)-1]
+		if len(line) == 0 { // エントリの区切り
+			if len(e) > 0 {
+				return e, nil
+			}
+			continue
+		}
+		if name, value, ok := bytes.Cut(line, []byte("=")); ok {
+			e[string(name)] = string(value)
+			continue
+		}
+		var size uint64
+		if err := binary.Read(r.br, binary.LittleEndian, &size); err != nil {
+			return nil, unexpected(err)
+		}
+		if size > maxFieldSize {
+			return nil, errField
+		}
+		value := make([]byte, size+1)
+		if _, err := io.ReadFull(r.br, value); err != nil {
+			return nil, unexpected(err)
+		}
+		if value[size] != '\n' {
+			return nil, errField
+		}
+		e[string(line)] = string(value[:size])
+	}
+}
+
+// readLine 改行までを読み込む。maxFieldSizeを超える場合はerrTooLong
+func (r *Reader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		b, err := r.br.ReadSlice('\n')
+		if len(line)+len(b) > maxFieldSize {
+			return nil, errTooLong
+		}
+		line = append(line, b...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Serve lnへの接続毎にexport formatを読み込みhandleに渡す
+// ctxが終了するかlnがCloseされるまで待ち受ける。handleは接続毎のgoroutineから呼ばれる
+func Serve(ctx context.Context, ln net.Listener, handle func(Entry), logger *slog.Logger) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			stop := context.AfterFunc(ctx, func() { conn.Close() })
+			defer stop()
+			if err := Read(conn, handle); err != nil && ctx.Err() == nil {
+				logger.Warn("journal: read failed", "remote", conn.RemoteAddr(), "err", err)
+			}
+		}()
+	}
+}
+
+// Read rの全てのエントリをhandleに渡す
+func Read(r io.Reader, handle func(Entry)) error {
+	jr := NewReader(r)
+	for {
+		e, err := jr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		handle(e)
+	}
+}
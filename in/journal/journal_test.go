@@ -0,0 +1,53 @@
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReader(t *testing.T) {
+	var b bytes.Buffer
+	b.WriteString("__REALTIME_TIMESTAMP=1700000000123456\nMESSAGE=hello\n_PID=1\n\n")
+	b.WriteString("MESSAGE\n")
+	binary.Write(&b, binary.LittleEndian, uint64(7))
+	b.WriteString("a\nb=\x00cd\n")
+	b.WriteString("PRIORITY=6\n") // 最後のエントリの後の空行は省略できる
+
+	r := NewReader(&b)
+	e, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e["MESSAGE"] != "hello" || e["_PID"] != "1" || len(e) != 3 {
+		t.Errorf("got %v", e)
+	}
+	if tm, ok := e.Time(); !ok || !tm.Equal(time.UnixMicro(1700000000123456)) {
+		t.Errorf("time %s", tm)
+	}
+	if e, err = r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if e["MESSAGE"] != "a\nb=\x00cd" || e["PRIORITY"] != "6" {
+		t.Errorf("got %q", e)
+	}
+	if _, ok := e.Time(); ok {
+		t.Error("time without __REALTIME_TIMESTAMP")
+	}
+	if _, err = r.Next(); err != io.EOF {
+		t.Errorf("got %v, want EOF", err)
+	}
+
+	r = NewReader(bytes.NewReader([]byte("MESSAGE\n\x10\x00\x00\x00\x00\x00\x00\x00short")))
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v, want ErrUnexpectedEOF", err)
+	}
+
+	r = NewReader(strings.NewReader("MESSAGE=" + strings.Repeat("a", maxFieldSize) + "\n"))
+	if _, err := r.Next(); err != errTooLong {
+		t.Errorf("got %v, want %v", err, errTooLong)
+	}
+}
@@ -0,0 +1,194 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxMessageSize 受信する1メッセージの最大サイズ
+const maxMessageSize = 64 * 1024
+
+// Server UDPまたはTCPでsyslogを受信する
+type Server struct {
+	Location *time.Location // タイムゾーンのない時刻のタイムゾーン (nilはtime.Local)
+	Logger   *slog.Logger
+
+	pc net.PacketConn
+	ln net.Listener
+}
+
+// Listen networkはudp, udp4, udp6, tcp, tcp4, tcp6
+func Listen(network, addr string) (*Server, error) {
+	s := &Server{}
+	var err error
+	switch network {
+	case "udp", "udp4", "udp6":
+		s.pc, err = net.ListenPacket(network, addr)
+	case "tcp", "tcp4", "tcp6":
+		s.ln, err = net.Listen(network, addr)
+	default:
+		return nil, fmt.Errorf("syslog: unknown network %q", network)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Addr 待ち受けアドレス
+func (s *Server) Addr() net.Addr {
+	if s.pc != nil {
+		return s.pc.LocalAddr()
+	}
+	return s.ln.Addr()
+}
+
+// Close 待ち受けを終了する
+func (s *Server) Close() error {
+	if s.pc != nil {
+		return s.pc.Close()
+	}
+	return s.ln.Close()
+}
+
+// Serve ctxが終了するかCloseされるまで受信したメッセージをhandleに渡す
+// TCPの場合handleは接続毎のgoroutineから呼ばれる
+func (s *Server) Serve(ctx context.Context, handle func(*Message)) error {
+	if s.Logger == nil {
+		s.Logger = slog.Default()
+	}
+	if s.Location == nil {
+		s.Location = time.Local
+	}
+	stop := context.AfterFunc(ctx, func() { s.Close() })
+	defer stop()
+	var err error
+	if s.pc != nil {
+		err = s.serveUDP(handle)
+	} else {
+		err = s.serveTCP(ctx, handle)
+	}
+	if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// message 解析できないメッセージは全体をMessageとする
+func (s *Server) message(b []byte, remote net.Addr) *Message {
+	now := time.Now()
+	m, err := Parse(b, now, s.Location)
+	if err != nil {
+		s.Logger.Debug("syslog: parse failed", "remote", remote, "err", err)
+		m = &Message{Time: now, Facility: defaultFacility, Severity: defaultSeverity, Message: strings.TrimRight(string(b), "\r\n\x00")}
+	}
+	if remote != nil {
+		m.Remote = remote.String()
+	}
+	return m
+}
+
+func (s *Server) serveUDP(handle func(*Message)) error {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		handle(s.message(buf[:n], addr))
+	}
+}
+
+func (s *Server) serveTCP(ctx context.Context, handle func(*Message)) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			stop := context.AfterFunc(ctx, func() { conn.Close() })
+			defer stop()
+			br := bufio.NewReader(conn)
+			for {
+				b, err := readFrame(br)
+				if len(b) > 0 {
+					handle(s.message(b, conn.RemoteAddr()))
+				}
+				if err != nil {
+					if err != io.EOF && ctx.Err() == nil {
+						s.Logger.Warn("syslog: read failed", "remote", conn.RemoteAddr(), "err", err)
+					}
+					return
+				}
+			}
+		}()
+	}
+}
+
+var (
+	errFrame   = errors.New("syslog: invalid frame")
+	errTooLong = errors.New("syslog: message too long")
+)
+
+// readLine delimまでを読み込む。maxバイトを超える場合はerrTooLong
+func readLine(br *bufio.Reader, delim byte, max int) ([]byte, error) {
+	var line []byte
+	for {
+		b, err := br.ReadSlice(delim)
+		if len(line)+len(b) > max {
+			return nil, errTooLong
+		}
+		line = append(line, b...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// readFrame RFC6587のoctet-counting (MSG-LEN SP MSG) または改行区切りの1メッセージ
+func readFrame(br *bufio.Reader) ([]byte, error) {
+	for {
+		c, err := br.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case c[0] == '\n' || c[0] == '\r' || c[0] == 0:
+			br.ReadByte()
+			continue
+		case c[0] >= '1' && c[0] <= '9':
+			n, err := readLine(br, ' ', len(strconv.Itoa(maxMessageSize))+1)
+			if err != nil {
+				return nil, err
+			}
+			size, err := strconv.Atoi(strings.TrimSuffix(string(n), " "))
+			if err != nil || size > maxMessageSize {
+				return nil, errFrame
+			}
+			b := make([]byte, size)
+			if _, err := io.ReadFull(br, b); err != nil {
+				return nil, err
+			}
+			return b, nil
+		}
+		b, err := readLine(br, '\n', maxMessageSize+1)
+		if err == io.EOF && len(b) > 0 {
+			return b, nil
+		}
+		return b, err
+	}
+}
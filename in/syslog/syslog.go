@@ -0,0 +1,185 @@
+package syslog
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message 受信したsyslogのメッセージ (RFC3164, RFC5424)
+type Message struct {
+	Time           time.Time `json:"time"`
+	Facility       int       `json:"facility"`
+	Severity       int       `json:"severity"`
+	Version        int       `json:"version,omitempty"` // RFC5424のみ
+	Hostname       string    `json:"hostname,omitempty"`
+	AppName        string    `json:"app_name,omitempty"`
+	ProcID         string    `json:"proc_id,omitempty"`
+	MsgID          string    `json:"msg_id,omitempty"`          // RFC5424のみ
+	StructuredData string    `json:"structured_data,omitempty"` // RFC5424のみ。[]を含む元の文字列
+	Message        string    `json:"message"`
+	Remote         string    `json:"remote,omitempty"` // 送信元アドレス
+}
+
+// PRIがない場合の値 (RFC3164 4.3.3)
+const (
+	defaultFacility = 1 // user-level
+	defaultSeverity = 5 // notice
+)
+
+const nilValue = "-"
+
+var (
+	errPRI       = errors.New("syslog: invalid PRI")
+	errHeader    = errors.New("syslog: invalid RFC5424 header")
+	errTimestamp = errors.New("syslog: invalid timestamp")
+	errSD        = errors.New("syslog: invalid structured data")
+)
+
+// Parse RFC5424またはRFC3164の1メッセージを解析する
+// タイムゾーンのない時刻はloc、時刻のない場合はnowを使う
+func Parse(b []byte, now time.Time, loc *time.Location) (*Message, error) {
+	b = bytes.TrimRight(b, "\r\n\x00")
+	m := &Message{Facility: defaultFacility, Severity: defaultSeverity}
+	if len(b) > 0 && b[0] == '<' {
+		end := bytes.IndexByte(b, '>')
+		if end < 2 || end > 4 {
+			return nil, errPRI
+		}
+		pri, err := strconv.Atoi(string(b[1:end]))
+		if err != nil || pri > 191 {
+			return nil, errPRI
+		}
+		m.Facility, m.Severity = pri/8, pri%8
+		b = b[end+1:]
+		if len(b) > 1 && b[0] >= '1' && b[0] <= '9' && (b[1] == ' ' || b[1] >= '0' && b[1] <= '9') {
+			return m, parse5424(m, string(b), now)
+		}
+	}
+	parse3164(m, string(b), now, loc)
+	return m, nil
+}
+
+// parse5424 VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID SP SD [SP MSG]
+func parse5424(m *Message, s string, now time.Time) error {
+	f := strings.SplitN(s, " ", 7)
+	if len(f) < 7 {
+		return errHeader
+	}
+	v, err := strconv.Atoi(f[0])
+	if err != nil {
+		return errHeader
+	}
+	m.Version = v
+	m.Time = now
+	if f[1] != nilValue {
+		if m.Time, err = time.Parse(time.RFC3339Nano, f[1]); err != nil {
+			return errTimestamp
+		}
+	}
+	m.Hostname, m.AppName, m.ProcID, m.MsgID = value(f[2]), value(f[3]), value(f[4]), value(f[5])
+	sd, msg, err := splitSD(f[6])
+	if err != nil {
+		return err
+	}
+	m.StructuredData = value(sd)
+	m.Message = strings.TrimPrefix(msg, "\ufeff") // UTF-8のBOM
+	return nil
+}
+
+func value(s string) string {
+	if s == nilValue {
+		return ""
+	}
+	return s
+}
+
+// splitSD STRUCTURED-DATAとMSGに分ける。PARAM-VALUEの中の \" \\ \] はエスケープ
+func splitSD(s string) (sd, msg string, err error) {
+	if s == nilValue || strings.HasPrefix(s, nilValue+" ") {
+		return nilValue, strings.TrimPrefix(s[1:], " "), nil
+	}
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		quoted := false
+		for i++; ; i++ {
+			if i >= len(s) {
+				return "", "", errSD
+			}
+			c := s[i]
+			if quoted && c == '\\' {
+				i++
+				continue
+			}
+			if c == '"' {
+				quoted = !quoted
+			} else if c == ']' && !quoted {
+				i++
+				break
+			}
+		}
+	}
+	if i == 0 {
+		return "", "", errSD
+	}
+	return s[:i], strings.TrimPrefix(s[i:], " "), nil
+}
+
+const stampLayout = "Jan _2 15:04:05"
+
+// parse3164 TIMESTAMP SP HOSTNAME SP TAG[PID]: MSG
+// 形式に合わない部分はメッセージとして扱う (RFC3164 4.3)
+func parse3164(m *Message, s string, now time.Time, loc *time.Location) {
+	m.Time = now
+	t, rest, ok := parseStamp(s, now, loc)
+	if !ok {
+		m.Message = s
+		return
+	}
+	m.Time = t
+	if host, r, ok := strings.Cut(rest, " "); ok && host != "" {
+		m.Hostname, rest = host, r
+	}
+	m.Message = rest
+	// TAGは32文字以下の英数字 (実際には - _ . / も使われる)
+	end := strings.IndexAny(rest, ":[ ")
+	if end <= 0 || end > 48 {
+		return
+	}
+	tag, r := rest[:end], rest[end:]
+	if r[0] == '[' {
+		pid, after, ok := strings.Cut(r[1:], "]")
+		if !ok {
+			return
+		}
+		m.ProcID, r = pid, after
+	}
+	if !strings.HasPrefix(r, ":") {
+		return
+	}
+	m.AppName = tag
+	m.Message = strings.TrimPrefix(r[1:], " ")
+}
+
+// parseStamp 先頭の "Mmm dd hh:mm:ss" またはRFC3339の時刻
+// 年のない時刻はnowの年とし、1日以上未来になる場合は前年とする
+func parseStamp(s string, now time.Time, loc *time.Location) (time.Time, string, bool) {
+	if len(s) >= len(stampLayout)+1 && s[len(stampLayout)] == ' ' {
+		if t, err := time.ParseInLocation(stampLayout, s[:len(stampLayout)], loc); err == nil {
+			n := now.In(loc)
+			t = time.Date(n.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc)
+			if t.Sub(n) > 24*time.Hour {
+				t = t.AddDate(-1, 0, 0)
+			}
+			return t, s[len(stampLayout)+1:], true
+		}
+	}
+	if stamp, rest, ok := strings.Cut(s, " "); ok {
+		if t, err := time.Parse(time.RFC3339Nano, stamp); err == nil {
+			return t, rest, true
+		}
+	}
+	return time.Time{}, s, false
+}
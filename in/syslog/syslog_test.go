@@ -0,0 +1,95 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, e := range []struct {
+		in   string
+		want Message
+	}{
+		{"<34>Oct 11 22:14:15 mymachine su: 'su root' failed\n",
+			Message{Time: time.Date(2023, 10, 11, 22, 14, 15, 0, time.UTC), Facility: 4, Severity: 2, Hostname: "mymachine", AppName: "su", Message: "'su root' failed"}},
+		{"<13>Jan  2 03:00:00 host sshd[123]: Accepted",
+			Message{Time: time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC), Facility: 1, Severity: 5, Hostname: "host", AppName: "sshd", ProcID: "123", Message: "Accepted"}},
+		{"no header",
+			Message{Time: now, Facility: 1, Severity: 5, Message: "no header"}},
+		{`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="App\]"] ` + "\ufeff" + "An event",
+			Message{Time: time.Date(2003, 10, 11, 22, 14, 15, 3e6, time.UTC), Facility: 20, Severity: 5, Version: 1, Hostname: "mymachine.example.com", AppName: "evntslog", MsgID: "ID47",
+				StructuredData: `[exampleSDID@32473 iut="3" eventSource="App\]"]`, Message: "An event"}},
+		{"<14>1 - - - - - -",
+			Message{Time: now, Facility: 1, Severity: 6, Version: 1}},
+	} {
+		m, err := Parse([]byte(e.in), now, time.UTC)
+		if err != nil {
+			t.Errorf("%q: %s", e.in, err)
+			continue
+		}
+		if !m.Time.Equal(e.want.Time) {
+			t.Errorf("%q: time %s, want %s", e.in, m.Time, e.want.Time)
+		}
+		m.Time = e.want.Time
+		if *m != e.want {
+			t.Errorf("%q: got %+v, want %+v", e.in, *m, e.want)
+		}
+	}
+	for _, in := range []string{"<999>x", "<1>1 2003-10-11T22:14:15Z host", "<1>1 bad host app - - - msg", "<1>1 - h a p m [x"} {
+		if _, err := Parse([]byte(in), now, time.UTC); err == nil {
+			t.Errorf("%q: no error", in)
+		}
+	}
+}
+
+func TestReadFrameTooLong(t *testing.T) {
+	for _, in := range []string{strings.Repeat("a", maxMessageSize+1) + "\n", "1234567890123 x"} {
+		if _, err := readFrame(bufio.NewReader(strings.NewReader(in))); err != errTooLong {
+			t.Errorf("%.20q: got %v, want %v", in, err, errTooLong)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	for _, network := range []string{"udp", "tcp"} {
+		s, err := Listen(network, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		msgs := make(chan *Message, 10)
+		done := make(chan error)
+		go func() { done <- s.Serve(ctx, func(m *Message) { msgs <- m }) }()
+		conn, err := net.Dial(network, s.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"a", "b c"}
+		if network == "udp" {
+			conn.Write([]byte("<13>1 - - app - - - a"))
+			conn.Write([]byte("<13>1 - - app - - - b c\n"))
+		} else { // octet-countingと改行区切り
+			conn.Write([]byte("21 <13>1 - - app - - - a<13>1 - - app - - - b c\n"))
+		}
+		for _, w := range want {
+			select {
+			case m := <-msgs:
+				if m.Message != w || m.AppName != "app" || m.Remote == "" {
+					t.Errorf("%s: got %+v, want %q", network, m, w)
+				}
+			case <-ctx.Done():
+				t.Fatalf("%s: timeout", network)
+			}
+		}
+		conn.Close()
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("%s: Serve: %s", network, err)
+		}
+	}
+}
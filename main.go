@@ -2,11 +2,16 @@ package main
 
 import (
 	"context"
-	"log"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/masahide/ftailer/in/ftail"
+	"github.com/masahide/ftailer/metrics"
 	"github.com/masahide/ftailer/tail"
 	"github.com/masahide/ftailer/tailex"
 )
@@ -64,7 +69,72 @@ var (
 	}
 )
 
+// httpAddr /metrics, /status を公開するアドレス。空の場合は公開しない
+var httpAddr = "127.0.0.1:9720"
+
+// stuckThreshold この時間より長く止まっているソースがあれば /healthz, /readyz は失敗する
+var stuckThreshold = 5 * time.Minute
+
+func serveHTTP(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default)
+	mux.Handle("/status", ftail.StatusHandler())
+	mux.Handle("/healthz", ftail.HealthHandler(ftail.Live, stuckThreshold))
+	mux.Handle("/readyz", ftail.HealthHandler(ftail.Ready, stuckThreshold))
+	slog.Info("http server listening", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("http server failed", "err", err)
+	}
+}
+
+// ログの出力形式とレベル
+var (
+	logFormat = "text" // text または json
+	logLevel  = "info" // debug, info, warn, error
+)
+
+// newLogger logFormat, logLevelのLogger
+func newLogger(format, level string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
 func main() {
+	flag.StringVar(&httpAddr, "http", httpAddr, "listen address of the /metrics, /status, /healthz and /readyz endpoints (empty to disable)")
+	flag.DurationVar(&stuckThreshold, "stuck", stuckThreshold, "health checks fail when a source is stuck longer than this")
+	flag.StringVar(&logFormat, "log-format", logFormat, "log format: text or json")
+	flag.StringVar(&logLevel, "log-level", logLevel, "log level: debug, info, warn or error")
+	start := flag.String("start", "", "re-read the sources from this position ignoring the stored positions: beginning, end, offset:N, lines:N or time:RFC3339")
+	flag.Parse()
+	logger, err := newLogger(logFormat, logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+	testlogrotateConfig.Logger = logger
+	if *start != "" {
+		if _, err := ftail.ParseStart(*start); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		testlogrotateConfig.Start = *start
+		testlogrotateConfig.ForceStart = true
+	}
+	if httpAddr != "" {
+		go serveHTTP(httpAddr)
+	}
+
 	var (
 		ctx    context.Context
 		cancel context.CancelFunc
@@ -78,14 +148,14 @@ func main() {
 		go func() {
 			err := ftail.Start(ctx, registLogConfig)
 			if err != nil {
-				log.Printf("ftail.Start err:%v", err)
+				slog.Error("ftail.Start failed", "err", err)
 			}
 		}()
 		wg.Add(1)
 		go func() {
 			err := ftail.Start(ctx, accessLogConfig)
 			if err != nil {
-				log.Printf("ftail.Start err:%v", err)
+				slog.Error("ftail.Start failed", "err", err)
 			}
 		}()
 	*/
@@ -95,7 +165,7 @@ func main() {
 	go func() {
 		err := ftail.Start(ctx, testlogrotateConfig, w)
 		if err != nil {
-			log.Printf("ftail.Start err:%v", err)
+			slog.Error("ftail.Start failed", "source", testlogrotateConfig.Name, "err", err)
 		}
 	}()
 
@@ -0,0 +1,251 @@
+// Package metrics Prometheusのテキスト形式で公開する最小限のメトリクス
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Labels メトリクスのラベル
+type Labels map[string]string
+
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	s := make([]string, len(keys))
+	for i, k := range keys {
+		s[i] = k + "=" + strconv.Quote(l[k])
+	}
+	return "{" + strings.Join(s, ",") + "}"
+}
+
+// With lにk=vを加えたラベル
+func (l Labels) With(k, v string) Labels {
+	res := make(Labels, len(l)+1)
+	for lk, lv := range l {
+		res[lk] = lv
+	}
+	res[k] = v
+	return res
+}
+
+// Counter 増加のみの値
+type Counter struct {
+	v uint64
+}
+
+func (c *Counter) Inc()          { atomic.AddUint64(&c.v, 1) }
+func (c *Counter) Add(n uint64)  { atomic.AddUint64(&c.v, n) }
+func (c *Counter) Value() uint64 { return atomic.LoadUint64(&c.v) }
+
+// Gauge 増減する値
+type Gauge struct {
+	bits uint64
+}
+
+func (g *Gauge) Set(v float64) { atomic.StoreUint64(&g.bits, math.Float64bits(v)) }
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// DefBuckets Histogramのデフォルトのバケット (秒)
+var DefBuckets = []float64{.001, .005, .01, .05, .1, .5, 1, 5}
+
+// Histogram 値の分布
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+type family struct {
+	name    string
+	help    string
+	typ     string
+	metrics map[string]interface{} // Labels.String() -> *Counter, *Gauge, func() float64, *Histogram
+	labels  map[string]Labels
+}
+
+// Registry メトリクスの登録先
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// Default ftailerのメトリクスの登録先
+var Default = NewRegistry()
+
+// get 同じ名前とラベルのメトリクスがあればそれを返す。なければnewで作成する
+func (r *Registry) get(name, help, typ string, labels Labels, new func() interface{}) interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{name: name, help: help, typ: typ, metrics: map[string]interface{}{}, labels: map[string]Labels{}}
+		r.families[name] = f
+	} else if f.typ != typ {
+		panic(fmt.Sprintf("metrics: %s is already registered as %s", name, f.typ))
+	}
+	key := labels.String()
+	if m, ok := f.metrics[key]; ok {
+		return m
+	}
+	m := new()
+	f.metrics[key] = m
+	f.labels[key] = labels
+	return m
+}
+
+// Counter nameとlabelsのCounterを返す
+func (r *Registry) Counter(name, help string, labels Labels) *Counter {
+	return r.get(name, help, typeCounter, labels, func() interface{} { return &Counter{} }).(*Counter)
+}
+
+// Gauge nameとlabelsのGaugeを返す
+func (r *Registry) Gauge(name, help string, labels Labels) *Gauge {
+	return r.get(name, help, typeGauge, labels, func() interface{} { return &Gauge{} }).(*Gauge)
+}
+
+// GaugeFunc 出力時にfnを呼び出すGaugeを登録する。同じnameとlabelsの場合は置き換える
+func (r *Registry) GaugeFunc(name, help string, labels Labels, fn func() float64) {
+	r.get(name, help, typeGauge, labels, func() interface{} { return fn })
+	r.mu.Lock()
+	r.families[name].metrics[labels.String()] = fn
+	r.mu.Unlock()
+}
+
+// Histogram nameとlabelsのHistogramを返す。bucketsがnilの場合はDefBuckets
+func (r *Registry) Histogram(name, help string, labels Labels, buckets []float64) *Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	return r.get(name, help, typeHistogram, labels, func() interface{} {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	}).(*Histogram)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// WriteTo テキスト形式で全てのメトリクスを出力する
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	r.mu.Unlock()
+	sort.Strings(names)
+	cw := &countWriter{w: w}
+	for _, name := range names {
+		r.mu.Lock()
+		f := r.families[name]
+		keys := make([]string, 0, len(f.metrics))
+		for k := range f.metrics {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		metrics := make([]interface{}, len(keys))
+		labels := make([]Labels, len(keys))
+		for i, k := range keys {
+			metrics[i], labels[i] = f.metrics[k], f.labels[k]
+		}
+		r.mu.Unlock()
+		if len(keys) == 0 {
+			continue
+		}
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+		for i, m := range metrics {
+			writeMetric(cw, f.name, labels[i], m)
+		}
+	}
+	return cw.n, cw.err
+}
+
+func writeMetric(w io.Writer, name string, l Labels, m interface{}) {
+	switch m := m.(type) {
+	case *Counter:
+		fmt.Fprintf(w, "%s%s %d\n", name, l, m.Value())
+	case *Gauge:
+		fmt.Fprintf(w, "%s%s %s\n", name, l, formatFloat(m.Value()))
+	case func() float64:
+		fmt.Fprintf(w, "%s%s %s\n", name, l, formatFloat(m()))
+	case *Histogram:
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for i, b := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, l.With("le", formatFloat(b)), m.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, l.With("le", "+Inf"), m.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, l, formatFloat(m.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, l, m.count)
+	}
+}
+
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+// ServeHTTP /metrics のハンドラ
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := r.WriteTo(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+	l := Labels{"source": "access_log"}
+	r.Counter("lines_total", "Lines.", l).Add(3)
+	r.Counter("lines_total", "Lines.", l).Inc()
+	r.Gauge("lag_bytes", "Lag.", l).Set(1.5)
+	r.GaugeFunc("workers", "Workers.", nil, func() float64 { return 2 })
+	h := r.Histogram("flush_seconds", "Flush.", l, []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	var b bytes.Buffer
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP flush_seconds Flush.
+# TYPE flush_seconds histogram
+flush_seconds_bucket{le="0.1",source="access_log"} 1
+flush_seconds_bucket{le="1",source="access_log"} 2
+flush_seconds_bucket{le="+Inf",source="access_log"} 2
+flush_seconds_sum{source="access_log"} 0.55
+flush_seconds_count{source="access_log"} 2
+# HELP lag_bytes Lag.
+# TYPE lag_bytes gauge
+lag_bytes{source="access_log"} 1.5
+# HELP lines_total Lines.
+# TYPE lines_total counter
+lines_total{source="access_log"} 4
+# HELP workers Workers.
+# TYPE workers gauge
+workers 2
+`
+	if b.String() != want {
+		t.Errorf("WriteTo =>\n%s\nwant\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,146 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Record パース結果の1行分のフィールド
+type Record map[string]interface{}
+
+// Parser 1行をRecordに変換する
+type Parser interface {
+	Parse(line []byte) (Record, error)
+}
+
+var (
+	// ErrNoMatch 行がフォーマットに一致しない
+	ErrNoMatch = errors.New("line does not match format")
+)
+
+// New 名前からParserを作る。"regexp" の場合はpatternに名前付きグループを含む正規表現を指定する
+func New(name, pattern string) (Parser, error) {
+	switch strings.ToLower(name) {
+	case "combined", "apache", "nginx":
+		return Combined(), nil
+	case "ltsv":
+		return LTSV(), nil
+	case "json":
+		return JSON(), nil
+	case "regexp", "regex":
+		return Regexp(pattern)
+	}
+	return nil, fmt.Errorf("unknown parser %q", name)
+}
+
+func trimEOL(line []byte) []byte {
+	return bytes.TrimRight(line, "\r\n")
+}
+
+// RegexpParser 名前付きグループをフィールド名とする正規表現パーサ
+type RegexpParser struct {
+	re    *regexp.Regexp
+	names []string
+}
+
+// Regexp 名前付きグループ (?P<name>...) を含む正規表現からParserを作る
+func Regexp(pattern string) (*RegexpParser, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	names := re.SubexpNames()
+	named := false
+	for _, n := range names {
+		if n != "" {
+			named = true
+		}
+	}
+	if !named {
+		return nil, fmt.Errorf("pattern %q has no named group", pattern)
+	}
+	return &RegexpParser{re: re, names: names}, nil
+}
+
+func (p *RegexpParser) Parse(line []byte) (Record, error) {
+	m := p.re.FindSubmatch(trimEOL(line))
+	if m == nil {
+		return nil, ErrNoMatch
+	}
+	r := make(Record, len(p.names))
+	for i, n := range p.names {
+		if n == "" || m[i] == nil {
+			continue
+		}
+		r[n] = string(m[i])
+	}
+	return r, nil
+}
+
+// Apache/nginx の combined (common) log format
+const combinedPattern = `^(?P<host>\S+) (?P<ident>\S+) (?P<user>\S+) \[(?P<time>[^\]]+)\] "(?P<request>[^"]*)" (?P<status>\d{3}|-) (?P<size>\d+|-)(?: "(?P<referer>[^"]*)" "(?P<agent>[^"]*)")?`
+
+type combinedParser struct {
+	*RegexpParser
+}
+
+// Combined Apache/nginx の combined log format パーサ
+// requestはmethod, path, protocolにも分割する
+func Combined() Parser {
+	p, err := Regexp(combinedPattern)
+	if err != nil {
+		panic(err)
+	}
+	return combinedParser{p}
+}
+
+func (p combinedParser) Parse(line []byte) (Record, error) {
+	r, err := p.RegexpParser.Parse(line)
+	if err != nil {
+		return nil, err
+	}
+	req, _ := r["request"].(string)
+	if f := strings.Fields(req); len(f) == 3 {
+		r["method"], r["path"], r["protocol"] = f[0], f[1], f[2]
+	}
+	return r, nil
+}
+
+type ltsvParser struct{}
+
+// LTSV Labeled Tab-separated Values パーサ
+func LTSV() Parser { return ltsvParser{} }
+
+func (ltsvParser) Parse(line []byte) (Record, error) {
+	r := Record{}
+	for _, f := range bytes.Split(trimEOL(line), []byte{'\t'}) {
+		i := bytes.IndexByte(f, ':')
+		if i <= 0 {
+			return nil, ErrNoMatch
+		}
+		r[string(f[:i])] = string(f[i+1:])
+	}
+	return r, nil
+}
+
+type jsonParser struct{}
+
+// JSON 1行1オブジェクトのJSONパーサ
+func JSON() Parser { return jsonParser{} }
+
+func (jsonParser) Parse(line []byte) (Record, error) {
+	var r Record
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&r); err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, ErrNoMatch
+	}
+	return r, nil
+}
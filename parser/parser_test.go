@@ -0,0 +1,80 @@
+package parser
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	var parseTest = []struct {
+		name    string
+		pattern string
+		input   string
+		output  Record
+	}{
+		{"combined", "",
+			`127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.0" 200 2326 "http://example.com/" "Mozilla/4.08"` + "\n",
+			Record{"host": "127.0.0.1", "ident": "-", "user": "frank", "time": "10/Oct/2000:13:55:36 -0700",
+				"request": "GET /a.gif HTTP/1.0", "method": "GET", "path": "/a.gif", "protocol": "HTTP/1.0",
+				"status": "200", "size": "2326", "referer": "http://example.com/", "agent": "Mozilla/4.08"}},
+		{"ltsv", "", "host:127.0.0.1\tstatus:200\n", Record{"host": "127.0.0.1", "status": "200"}},
+		{"json", "", `{"a":"b","c":{"d":1}}` + "\n", Record{"a": "b", "c": map[string]interface{}{"d": json1}}},
+		{"regexp", `^(?P<level>\w+): (?P<msg>.*)$`, "INFO: hello\n", Record{"level": "INFO", "msg": "hello"}},
+	}
+	for _, e := range parseTest {
+		p, err := New(e.name, e.pattern)
+		if err != nil {
+			t.Fatalf("New(%q) err:%s", e.name, err)
+		}
+		output, err := p.Parse([]byte(e.input))
+		if err != nil {
+			t.Errorf("%s Parse(%q) err:%s", e.name, e.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(output, e.output) {
+			t.Errorf("%s Parse(%q) => %#v, want %#v", e.name, e.input, output, e.output)
+		}
+	}
+	for _, name := range []string{"combined", "ltsv", "json"} {
+		p, _ := New(name, "")
+		if _, err := p.Parse([]byte("garbage\n")); err == nil {
+			t.Errorf("%s Parse(garbage) err is nil", name)
+		}
+	}
+}
+
+var json1 = json.Number("1")
+
+func TestTimeExtractor(t *testing.T) {
+	want := time.Date(2000, 10, 10, 20, 55, 36, 0, time.UTC)
+	var extractTest = []struct {
+		extractor TimeExtractor
+		input     string
+	}{
+		{TimeExtractor{Pattern: `\[([^\]]+)\]`, Layout: LayoutCombined},
+			`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 2326` + "\n"},
+		{TimeExtractor{Layout: time.RFC3339}, "2000-10-10T20:55:36Z hello\n"},
+		{TimeExtractor{JSONField: "time", Layout: LayoutUnix}, `{"time":971211336}` + "\n"},
+		{TimeExtractor{JSONField: "ts", Layout: LayoutUnixMilli}, `{"ts":"971211336000"}`},
+	}
+	for _, e := range extractTest {
+		ex, err := NewTimeExtractor(e.extractor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := ex.Extract([]byte(e.input))
+		if err != nil {
+			t.Errorf("Extract(%q) err:%s", e.input, err)
+			continue
+		}
+		if !output.Equal(want) {
+			t.Errorf("Extract(%q) => %s, want %s", e.input, output, want)
+		}
+	}
+	ex, _ := NewTimeExtractor(TimeExtractor{Pattern: `^(\S+)`, Layout: time.RFC3339})
+	if _, err := ex.Extract([]byte(" \n")); err != ErrNoTime {
+		t.Errorf("Extract(blank) err => %v, want %v", err, ErrNoTime)
+	}
+}
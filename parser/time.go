@@ -0,0 +1,128 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 時刻のLayoutに指定できる特殊な値
+const (
+	LayoutUnix      = "unix"      // 1970年からの秒 (小数可)
+	LayoutUnixMilli = "unixmilli" // 1970年からのミリ秒
+)
+
+// LayoutCombined Apache/nginx のcombined log formatの時刻
+const LayoutCombined = "02/Jan/2006:15:04:05 -0700"
+
+// ErrNoTime 行から時刻を取り出せない
+var ErrNoTime = errors.New("time not found in line")
+
+// TimeExtractor 行の内容から時刻(イベント時刻)を取り出す
+// JSONFieldを指定した場合はJSONのフィールド、それ以外はPatternで取り出した文字列をLayoutでパースする
+type TimeExtractor struct {
+	Pattern   string         // 時刻部分を取り出す正規表現。最初のサブマッチ(なければ一致全体)を使う。空の場合は行の先頭のLayoutと同じ数のフィールド
+	Layout    string         // time.Parse のlayout, LayoutUnix, LayoutUnixMilli
+	JSONField string         // JSON行の時刻フィールド名
+	Location  *time.Location // タイムゾーンを含まない時刻の場合のタイムゾーン (nilはtime.Local)
+
+	re *regexp.Regexp
+}
+
+// NewTimeExtractor 正規表現をコンパイルしたTimeExtractorを返す
+func NewTimeExtractor(e TimeExtractor) (*TimeExtractor, error) {
+	if e.Layout == "" {
+		return nil, fmt.Errorf("time extractor: layout is empty")
+	}
+	if e.Pattern != "" {
+		re, err := regexp.Compile(e.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("time extractor: %s", err)
+		}
+		e.re = re
+	}
+	if e.Location == nil {
+		e.Location = time.Local
+	}
+	return &e, nil
+}
+
+// Extract 行からイベント時刻を取り出す
+func (e *TimeExtractor) Extract(line []byte) (time.Time, error) {
+	v, err := e.value(trimEOL(line))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return e.parse(v)
+}
+
+func (e *TimeExtractor) value(line []byte) (string, error) {
+	switch {
+	case e.JSONField != "":
+		var r map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		if err := dec.Decode(&r); err != nil {
+			return "", err
+		}
+		switch v := r[e.JSONField].(type) {
+		case string:
+			return v, nil
+		case json.Number:
+			return v.String(), nil
+		}
+		return "", ErrNoTime
+	case e.re != nil:
+		m := e.re.FindSubmatch(line)
+		if m == nil {
+			return "", ErrNoTime
+		}
+		if len(m) > 1 {
+			return string(m[1]), nil
+		}
+		return string(m[0]), nil
+	}
+	// 行の先頭からLayoutと同じ数のフィールド
+	n := len(strings.Fields(e.Layout))
+	fields := strings.Fields(string(line))
+	if len(fields) < n {
+		return "", ErrNoTime
+	}
+	return strings.Join(fields[:n], " "), nil
+}
+
+func (e *TimeExtractor) parse(v string) (time.Time, error) {
+	switch e.Layout {
+	case LayoutUnix:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	case LayoutUnixMilli:
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, ms*int64(time.Millisecond)), nil
+	}
+	t, err := time.ParseInLocation(e.Layout, v, e.Location)
+	if err != nil {
+		return t, err
+	}
+	if t.Year() == 0 { // syslog形式など年を含まない場合
+		now := time.Now().In(e.Location)
+		t = t.AddDate(now.Year(), 0, 0)
+		if t.Sub(now) > 24*time.Hour {
+			t = t.AddDate(-1, 0, 0)
+		}
+	}
+	return t, nil
+}
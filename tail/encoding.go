@@ -0,0 +1,140 @@
+package tail
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// ErrInvalidEncoding 指定された文字コードとして不正なバイト列を含む行
+var ErrInvalidEncoding = errors.New("invalid byte sequence for encoding")
+
+// decoder Config.Encodingで指定された文字コードの行をUTF-8に変換する
+type decoder struct {
+	name      string
+	enc       encoding.Encoding
+	utf16     bool
+	bigEndian bool   // utf16の場合のみ有効
+	detect    bool   // BOMからbyte orderを判定する
+	fffd      []byte // encでU+FFFDを表すバイト列。表せない場合はnil
+}
+
+// newDecoder Encodingの名前からdecoderを作る。変換不要の場合はnilを返す
+func newDecoder(name string) (*decoder, error) {
+	n := strings.ToLower(strings.TrimSpace(name))
+	switch n {
+	case "", "utf-8", "utf8":
+		return nil, nil
+	case "utf-16", "utf16":
+		return &decoder{name: n, utf16: true, detect: true}, nil
+	case "utf-16le", "utf16le":
+		return &decoder{name: n, utf16: true}, nil
+	case "utf-16be", "utf16be":
+		return &decoder{name: n, utf16: true, bigEndian: true}, nil
+	}
+	enc, err := htmlindex.Get(n)
+	if err != nil {
+		return nil, fmt.Errorf("unknown encoding %q: %s", name, err)
+	}
+	d := &decoder{name: n, enc: enc}
+	if b, err := enc.NewEncoder().Bytes([]byte("\ufffd")); err == nil {
+		d.fffd = b
+	}
+	return d, nil
+}
+
+// sniff ファイル先頭のBOMを確認してbyte orderを決める
+// BOMが無い場合はlittle endianとして扱う
+func (d *decoder) sniff(r io.ReaderAt) {
+	if !d.utf16 || !d.detect {
+		return
+	}
+	bom := make([]byte, 2)
+	if _, err := r.ReadAt(bom, 0); err != nil {
+		d.bigEndian = false
+		return
+	}
+	d.bigEndian = bom[0] == 0xfe && bom[1] == 0xff
+}
+
+// readLine UTF-16の改行コード単位で1行読み込む
+func (d *decoder) readLine(r *bufio.Reader) ([]byte, error) {
+	if !d.utf16 {
+		return r.ReadBytes('\n')
+	}
+	var line []byte
+	for {
+		b, err := r.ReadBytes('\n')
+		line = append(line, b...)
+		if err != nil {
+			return line, err
+		}
+		n := len(line)
+		if d.bigEndian {
+			// 00 0a
+			if n%2 == 0 && line[n-2] == 0 {
+				return line, nil
+			}
+			continue
+		}
+		// 0a 00
+		if n%2 == 0 {
+			continue
+		}
+		c, err := r.ReadByte()
+		if err != nil {
+			return line, err
+		}
+		line = append(line, c)
+		if c == 0 {
+			return line, nil
+		}
+	}
+}
+
+// decode 1行をUTF-8に変換する。不正なバイト列はU+FFFDに置換してErrInvalidEncodingを返す
+// 元の行にあるU+FFFDは不正なバイト列として扱わない
+func (d *decoder) decode(b []byte) ([]byte, error) {
+	enc := d.enc
+	if d.utf16 {
+		order := unicode.LittleEndian
+		if d.bigEndian {
+			order = unicode.BigEndian
+		}
+		enc = unicode.UTF16(order, unicode.UseBOM)
+	}
+	out, _, err := transform.Bytes(enc.NewDecoder(), b)
+	if err != nil {
+		return b, err
+	}
+	if bytes.Count(out, []byte(string(utf8.RuneError))) > d.countFFFD(b) {
+		return out, ErrInvalidEncoding
+	}
+	return out, nil
+}
+
+// countFFFD 変換前の行に含まれるU+FFFDの数
+func (d *decoder) countFFFD(b []byte) int {
+	if !d.utf16 {
+		if d.fffd == nil {
+			return 0
+		}
+		return bytes.Count(b, d.fffd)
+	}
+	n := 0
+	for i := 0; i+1 < len(b); i += 2 {
+		if d.bigEndian && b[i] == 0xff && b[i+1] == 0xfd || !d.bigEndian && b[i] == 0xfd && b[i+1] == 0xff {
+			n++
+		}
+	}
+	return n
+}
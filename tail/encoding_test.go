@@ -0,0 +1,64 @@
+package tail
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestDecoderDecode(t *testing.T) {
+	var decodeTest = []struct {
+		encoding string
+		input    []byte
+		output   string
+		err      error
+	}{
+		{"shift_jis", []byte{0x82, 0xa0, 0x82, 0xa2, '\n'}, "あい\n", nil},
+		{"euc-jp", []byte{0xa4, 0xa2, 0xa4, 0xa4, '\n'}, "あい\n", nil},
+		{"Shift_JIS", []byte{'a', 0x82, '\n'}, "a�\n", ErrInvalidEncoding},
+		{"utf-16", []byte{0xff, 0xfe, 'a', 0, '\n', 0}, "a\n", nil},
+		{"utf-16be", []byte{0, 'a', 0, '\n'}, "a\n", nil},
+		{"utf-16le", []byte{0xfd, 0xff, '\n', 0}, "\ufffd\n", nil},
+		{"utf-16le", []byte{0xfd, 0xff, 0x00, 0xd8, '\n', 0}, "\ufffd\ufffd\n", ErrInvalidEncoding},
+		{"gb18030", []byte{0x84, 0x31, 0xa4, 0x37, '\n'}, "\ufffd\n", nil},
+	}
+	for _, e := range decodeTest {
+		d, err := newDecoder(e.encoding)
+		if err != nil {
+			t.Fatalf("newDecoder(%q) err:%s", e.encoding, err)
+		}
+		out, err := d.decode(e.input)
+		if string(out) != e.output || err != e.err {
+			t.Errorf("decode(%q, %x) => %q, %v, want %q, %v", e.encoding, e.input, out, err, e.output, e.err)
+		}
+	}
+	if d, err := newDecoder(""); d != nil || err != nil {
+		t.Errorf("newDecoder(\"\") => %v, %v, want nil, nil", d, err)
+	}
+	if _, err := newDecoder("no-such-encoding"); err == nil {
+		t.Errorf("newDecoder(\"no-such-encoding\") err is nil")
+	}
+}
+
+func TestDecoderReadLineUTF16(t *testing.T) {
+	// U+010A(0a 01) を含む行は途中で分割しない
+	data := []byte{0xff, 0xfe, 0x0a, 0x01, '\n', 0, 'b', 0, '\n', 0, 'c'}
+	d, _ := newDecoder("utf-16")
+	d.sniff(bytes.NewReader(data))
+	r := bufio.NewReader(bytes.NewReader(data))
+	var lines []int
+	for {
+		line, err := d.readLine(r)
+		if err == io.EOF {
+			if len(line) != 1 {
+				t.Errorf("rest => %x, want 1 byte", line)
+			}
+			break
+		}
+		lines = append(lines, len(line))
+	}
+	if len(lines) != 2 || lines[0] != 6 || lines[1] != 4 {
+		t.Errorf("line lengths => %v, want [6 4]", lines)
+	}
+}
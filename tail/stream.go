@@ -0,0 +1,282 @@
+package tail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Opener opens the next input of a Stream.
+// It returns io.EOF when there is no more input.
+type Opener func(ctx context.Context) (io.ReadCloser, error)
+
+const (
+	minRestartDelay = time.Second
+	maxRestartDelay = time.Minute
+)
+
+// Stream reads lines from inputs which can not be seeked: stdin, a named
+// pipe or the output of a command. Line.Offset is a byte counter which
+// continues across the inputs, and NewFileNotify is sent for each input.
+type Stream struct {
+	Name  string // Line.Filename
+	Lines chan *Line
+	Config
+
+	open   Opener
+	offset int64 // atomic
+	dec    *decoder
+	logger *slog.Logger
+}
+
+// NewStream starts reading the inputs returned by open until it returns
+// io.EOF or ctx is done, then Lines is closed. offset is the byte counter
+// of the previous run.
+func NewStream(ctx context.Context, name string, open Opener, config Config, offset int64) (*Stream, error) {
+	dec, err := newDecoder(config.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	s := &Stream{
+		Name:   name,
+		Lines:  make(chan *Line, config.LinesChanSize),
+		Config: config,
+		open:   open,
+		offset: offset,
+		dec:    dec,
+		logger: config.Logger,
+	}
+	if s.logger == nil {
+		s.logger = slog.Default()
+	}
+	s.logger = s.logger.With("input", name)
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer cancel()
+		s.readLoop(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		s.tickLoop(ctx)
+	}()
+	go func() {
+		wg.Wait()
+		close(s.Lines)
+	}()
+	return s, nil
+}
+
+func (s *Stream) send(ctx context.Context, l *Line) bool {
+	select {
+	case s.Lines <- l:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *Stream) tickLoop(ctx context.Context) {
+	if s.NotifyInterval == 0 {
+		return
+	}
+	ticker := time.NewTicker(s.NotifyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.send(ctx, &Line{NotifyType: TickerNotify, Time: time.Now(), Filename: s.Name, Offset: atomic.LoadInt64(&s.offset)})
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// readLoop opens the inputs and reads them until EOF.
+// An input which ends cleanly (e.g. all the writers of a FIFO closed it) is
+// reopened at once. An input which fails to open or read, or a command which
+// exits soon, is reopened after a growing delay.
+func (s *Stream) readLoop(ctx context.Context) {
+	delay := minRestartDelay
+	for {
+		start := time.Now()
+		rc, err := s.open(ctx)
+		if errors.Is(err, io.EOF) {
+			s.logger.Info("no more input")
+			return
+		}
+		clean := false
+		if err != nil {
+			s.logger.Warn("open failed", "err", err, "retry", delay)
+		} else {
+			err = s.read(ctx, rc)
+			cerr := rc.Close()
+			if cerr != nil {
+				s.logger.Warn("input closed", "err", cerr)
+			}
+			if err != nil && ctx.Err() == nil {
+				s.logger.Warn("read failed", "err", err)
+			}
+			_, command := rc.(*commandReader)
+			clean = err == nil && cerr == nil && !command
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if clean || time.Since(start) > maxRestartDelay { // しばらく動いていた場合はすぐに開き直す
+			delay = minRestartDelay
+			continue
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		if delay *= 2; delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+// read sends the lines of rc. The last line without a newline is also sent at EOF.
+func (s *Stream) read(ctx context.Context, rc io.ReadCloser) error {
+	now := time.Now()
+	if !s.send(ctx, &Line{NotifyType: NewFileNotify, Filename: s.Name, Offset: atomic.LoadInt64(&s.offset), Time: now, OpenTime: now}) {
+		return nil
+	}
+	// 入力を閉じて読み込み中のReadを止める
+	stop := context.AfterFunc(ctx, func() { rc.Close() })
+	defer stop()
+	br := bufio.NewReader(rc)
+	if s.dec != nil {
+		if bom, err := br.Peek(2); err == nil {
+			s.dec.sniff(bytes.NewReader(bom))
+		}
+	}
+	for {
+		var line []byte
+		var err error
+		if s.dec != nil {
+			line, err = s.dec.readLine(br)
+		} else {
+			line, err = br.ReadBytes('\n')
+		}
+		if len(line) > 0 {
+			offset := atomic.AddInt64(&s.offset, int64(len(line)))
+			l := &Line{NotifyType: NewLineNotify, Text: line, Time: time.Now(), Filename: s.Name, OpenTime: now, Offset: offset}
+			if s.dec != nil {
+				l.Raw = line
+				l.Text, l.Err = s.dec.decode(line)
+			}
+			if !s.send(ctx, l) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Stdin returns an Opener of os.Stdin. The Stream stops at the EOF of stdin.
+func Stdin() Opener {
+	opened := false
+	return func(ctx context.Context) (io.ReadCloser, error) {
+		if opened {
+			return nil, io.EOF
+		}
+		opened = true
+		return io.NopCloser(os.Stdin), nil
+	}
+}
+
+// FIFO returns an Opener of the named pipe path, which is reopened at
+// every EOF (when all the writers have closed it).
+func FIFO(path string) Opener {
+	return func(ctx context.Context) (io.ReadCloser, error) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if fi.Mode()&os.ModeNamedPipe == 0 {
+			return nil, fmt.Errorf("%s is not a named pipe", path)
+		}
+		type result struct {
+			f   *os.File
+			err error
+		}
+		ch := make(chan result, 1)
+		go func() { // 書き込み側が開くまでブロックする
+			f, err := os.Open(path)
+			ch <- result{f, err}
+		}()
+		select {
+		case r := <-ch:
+			return r.f, r.err
+		case <-ctx.Done():
+			// 自分で書き込み側を開いてブロックを解除する
+			if w, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+				w.Close()
+			}
+			if r := <-ch; r.f != nil {
+				r.f.Close()
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Command returns an Opener which runs the command args and reads its
+// stdout and stderr. The command is run again after it exits.
+func Command(args []string) Opener {
+	return func(ctx context.Context) (io.ReadCloser, error) {
+		if len(args) == 0 {
+			return nil, errors.New("command is empty")
+		}
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stdout, cmd.Stderr = pw, pw
+		if err := cmd.Start(); err != nil {
+			pr.Close()
+			pw.Close()
+			return nil, err
+		}
+		pw.Close() // 子プロセスが終了するとEOFになる
+		return &commandReader{File: pr, cmd: cmd}, nil
+	}
+}
+
+// commandReader the output of a running command. Close waits for the command.
+type commandReader struct {
+	*os.File
+	cmd  *exec.Cmd
+	once sync.Once
+	err  error
+}
+
+func (r *commandReader) Close() error {
+	r.once.Do(func() {
+		r.File.Close()
+		if err := r.cmd.Wait(); err != nil {
+			r.err = fmt.Errorf("command %s: %w", r.cmd.Path, err)
+		}
+	})
+	return r.err
+}
@@ -0,0 +1,60 @@
+//go:build !windows
+
+package tail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFIFO(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fifo")
+	if err := syscall.Mkfifo(path, 0644); err != nil {
+		t.Skip(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s, err := NewStream(ctx, path, FIFO(path), Config{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(text string) {
+		w, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		w.WriteString(text)
+		w.Close()
+	}
+	next := func() string {
+		for {
+			select {
+			case l := <-s.Lines:
+				if l.NotifyType == NewLineNotify {
+					return string(l.Text)
+				}
+			case <-ctx.Done():
+				t.Fatal("timeout")
+			}
+		}
+	}
+	// 書き込み側が閉じた後(EOF)の次の書き込み側もすぐに読み込む
+	start := time.Now()
+	go write("a\n")
+	if l := next(); l != "a\n" {
+		t.Fatalf("got %q", l)
+	}
+	time.Sleep(50 * time.Millisecond)
+	go write("b\n")
+	if l := next(); l != "b\n" {
+		t.Fatalf("got %q", l)
+	}
+	if d := time.Since(start); d >= minRestartDelay {
+		t.Errorf("reopen after EOF took %s", d)
+	}
+}
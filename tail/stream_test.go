@@ -0,0 +1,64 @@
+package tail
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	inputs := []string{"a\nbb\n", "ccc"}
+	open := func(ctx context.Context) (io.ReadCloser, error) {
+		if len(inputs) == 0 {
+			return nil, io.EOF
+		}
+		r := io.NopCloser(strings.NewReader(inputs[0]))
+		inputs = inputs[1:]
+		return r, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s, err := NewStream(ctx, "test", open, Config{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for l := range s.Lines {
+		switch l.NotifyType {
+		case NewFileNotify:
+			got = append(got, "open:"+strconv.FormatInt(l.Offset, 10))
+		case NewLineNotify:
+			got = append(got, string(l.Text)+":"+strconv.FormatInt(l.Offset, 10))
+		}
+	}
+	// 改行のない最後の行もEOFで送られ、オフセットは入力をまたいで増える
+	want := "open:10,a\n:12,bb\n:15,open:15,ccc:18"
+	if s := strings.Join(got, ","); s != want {
+		t.Errorf("got %q, want %q", s, want)
+	}
+	if ctx.Err() != nil {
+		t.Error("Lines was not closed at the end of the inputs")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rc, err := Command([]string{"sh", "-c", "echo out; echo err >&2"})(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "out\nerr\n" {
+		t.Errorf("got %q", b)
+	}
+}
@@ -3,9 +3,10 @@ package tail
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sync"
@@ -28,10 +29,12 @@ const (
 type Line struct {
 	Time       time.Time
 	Text       []byte
+	Raw        []byte // 文字コード変換前のバイト列 (Encoding指定時のみ)
 	Filename   string
 	Offset     int64
 	OpenTime   time.Time
-	Err        error // Error from tail
+	FileInfo   os.FileInfo // NewFileNotifyの場合、開いたファイルの情報
+	Err        error       // Error from tail
 	NotifyType int
 }
 
@@ -53,24 +56,62 @@ type Config struct {
 	ReOpen      bool          // Reopen recreated files (tail -F)
 	ReOpenDelay time.Duration // Reopen Delay
 
+	// Filename is a symlink retargeted at rotation (current.log -> app-20261017.log).
+	// The target is tailed, a retarget is a rotation and Line.Filename is the target path.
+	FollowSymlink bool
+
+	// What to do when the file is deleted, moved away or unmounted
+	// and not recreated within ReOpenDelay. Empty means RemoveWait.
+	RemovePolicy RemovePolicy
+
 	MustExist      bool // Fail early if the file does not exist
 	Poll           bool // Poll for file changes instead of using inotify
 	TruncateReOpen bool // copytruncate rotate
 	RenameReOpen   bool // rename rotate
 	LinesChanSize  int  // Lines channel size
 
+	// Interval to stat the file in addition to inotify events, to catch
+	// changes inotify missed. 0 means watch.DefaultStatInterval, negative disables it.
+	StatInterval time.Duration
+
+	// Polling interval after a change (Poll). 0 means watch.DefaultPollInterval.
+	// The interval doubles while the file is idle, up to MaxPollInterval
+	// (0 means watch.DefaultMaxPollInterval). Set both equal to poll at a fixed rate.
+	PollInterval    time.Duration
+	MaxPollInterval time.Duration
+
+	// Character encoding of the file (e.g. "shift_jis", "euc-jp", "utf-16").
+	// Lines are converted to UTF-8. Empty means no conversion.
+	Encoding string
+
 	// Generic IO
 	NotifyInterval time.Duration // Notice interval of the elapsed time
+
+	// Logger receives the logs of the tail and its watcher.
+	// nil means slog.Default().
+	Logger *slog.Logger
 }
 
+// RemovePolicy is the policy for a deleted, moved or unmounted file.
+type RemovePolicy string
+
+const (
+	RemoveWait  RemovePolicy = "wait"  // read the rest and wait for recreation if ReOpen (tail -F)
+	RemoveStop  RemovePolicy = "stop"  // stop without reading the rest
+	RemoveDrain RemovePolicy = "drain" // read the rest and stop
+)
+
+// ErrRemoved is the error of Err when the tail was stopped by RemoveStop or RemoveDrain.
+var ErrRemoved = errors.New("file was removed")
+
 type Tail struct {
 	Filename string
 	Lines    chan *Line
 	Config
 
-	tracker   *watch.InotifyTracker
 	ticker    *time.Ticker
 	openTime  time.Time
+	fileInfo  os.FileInfo // 開いているファイルの情報
 	WorkLimit chan bool
 
 	watcher watch.FileWatcher
@@ -79,7 +120,11 @@ type Tail struct {
 	Cancel  context.CancelFunc
 
 	reader *bufio.Reader
+	dec    *decoder
 	file   *os.File
+	target string // FollowSymlinkの場合に開いているリンク先
+	logger *slog.Logger
+	err    error // 停止した理由
 	mu     sync.RWMutex
 	//lastDelChReceived time.Time // Last delete channel received time
 }
@@ -95,32 +140,34 @@ func TailFile(ctx context.Context, filename string, config Config, w chan bool)
 		Config:    config,
 		WorkLimit: w,
 	}
+	dec, err := newDecoder(config.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	t.dec = dec
+	t.logger = config.Logger
+	if t.logger == nil {
+		t.logger = slog.Default()
+	}
+	t.logger = t.logger.With("file", filename)
 	t.Ctx, t.Cancel = context.WithCancel(ctx)
 
-	if t.Poll {
-		t.watcher = watch.NewPollingFileWatcher(filename)
-	} else {
-		t.tracker = watch.NewInotifyTracker()
-		w, err := t.tracker.NewWatcher(ctx)
-		if err != nil {
-			return nil, err
-		}
-		dw, err := t.tracker.NewWatcher(ctx)
-		if err != nil {
-			return nil, err
-		}
-		dirname := filepath.Dir(t.Filename)
-		// Watch for new files to be created in the parent directory.
-		err = dw.Watch(dirname)
-		if err != nil {
-			return nil, err
-		}
-		log.Printf("start InotifyFileWatcher: %s", filename)
-		t.watcher = watch.NewInotifyFileWatcher(filename, dw, w, t.ReOpenDelay)
+	watcher, err := watch.NewFileWatcher(filename, watch.Options{
+		Poll:            t.Poll,
+		ReOpenDelay:     t.ReOpenDelay,
+		StatInterval:    t.StatInterval,
+		Symlink:         t.FollowSymlink,
+		PollInterval:    t.PollInterval,
+		MaxPollInterval: t.MaxPollInterval,
+		Logger:          config.Logger,
+	})
+	if err != nil {
+		return nil, err
 	}
+	t.watcher = watcher
 
 	if t.MustExist {
-		file, err := os.Open(t.Filename)
+		file, err := t.open()
 		if err != nil {
 			return nil, err
 		}
@@ -172,10 +219,13 @@ func (tail *Tail) setReader(r io.Reader) {
 	defer tail.mu.Unlock()
 	tail.reader = bufio.NewReader(r)
 }
-func (tail *Tail) readerReadBytes(delim byte) (line []byte, err error) {
+func (tail *Tail) readerReadLine() (line []byte, err error) {
 	tail.mu.Lock()
 	defer tail.mu.Unlock()
-	return tail.reader.ReadBytes(delim)
+	if tail.dec != nil {
+		return tail.dec.readLine(tail.reader)
+	}
+	return tail.reader.ReadBytes('\n')
 }
 
 // Tell Return the file's current position, like stdio's ftell().
@@ -197,7 +247,7 @@ func (tail *Tail) tell() (offset int64, err error) {
 func (tail *Tail) close() {
 	if tail.getFile() != nil {
 		if err := tail.fileClose(); err != nil {
-			log.Printf("tail.file.Close err:%s", err)
+			tail.logger.Warn("file close failed", "err", err)
 		}
 	}
 	tail.setFile(nil)
@@ -206,15 +256,15 @@ func (tail *Tail) close() {
 func (tail *Tail) reopen(ctx context.Context) error {
 	if tail.getFile() != nil {
 		if err := tail.fileClose(); err != nil {
-			log.Printf("reopen error. file.Close:%s", err)
+			tail.logger.Error("file close failed on reopen", "err", err)
 			return err
 		}
 	}
 	for {
-		file, err := os.Open(tail.Filename)
+		file, err := tail.open()
 		if err != nil {
 			if os.IsNotExist(err) {
-				log.Printf("Waiting for %s to appear...", tail.Filename)
+				tail.logger.Info("waiting for the file to appear")
 				if err := tail.watcher.BlockUntilExists(ctx); err != nil {
 					return fmt.Errorf("Failed to detect creation of %s: %s", tail.Filename, err)
 				}
@@ -228,6 +278,37 @@ func (tail *Tail) reopen(ctx context.Context) error {
 	return nil
 }
 
+// open opens Filename, or its target if FollowSymlink.
+func (tail *Tail) open() (*os.File, error) {
+	name := tail.Filename
+	if tail.FollowSymlink {
+		target, err := filepath.EvalSymlinks(name)
+		if err != nil {
+			return nil, err
+		}
+		name = target
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if tail.FollowSymlink {
+		if tail.target != "" && tail.target != name {
+			tail.logger.Info("symlink target", "target", name, "old", tail.target)
+		}
+		tail.target = name
+	}
+	return file, nil
+}
+
+// path returns the path of the opened file: the symlink target if FollowSymlink.
+func (tail *Tail) path() string {
+	if tail.target != "" {
+		return tail.target
+	}
+	return tail.Filename
+}
+
 func (tail *Tail) readLine() ([]byte, error) {
 	select {
 	case tail.WorkLimit <- true:
@@ -235,7 +316,7 @@ func (tail *Tail) readLine() ([]byte, error) {
 	case <-tail.Ctx.Done():
 		return nil, tail.Ctx.Err()
 	}
-	line, err := tail.readerReadBytes(byte('\n'))
+	line, err := tail.readerReadLine()
 	if err != nil {
 		// Note ReadString "returns the data read before the error" in
 		// case of an error, including EOF, so we return it as is. The
@@ -264,7 +345,7 @@ func (tail *Tail) tailFileSync() {
 		// deferred first open.
 		err := tail.reopen(tail.Ctx)
 		if err != nil {
-			log.Printf("tail.reopen() err: %s", err)
+			tail.logger.Error("open failed", "err", err)
 			return
 		}
 	}
@@ -274,16 +355,16 @@ func (tail *Tail) tailFileSync() {
 	if tail.Location != nil {
 		offset = tail.Location.Offset
 		_, err := tail.fileSeek(offset, tail.Location.Whence)
-		log.Printf("Seeked %s - %+v\n", tail.Filename, tail.Location)
 		if err != nil {
-			log.Printf("Seek error on %s: %s", tail.Filename, err)
+			tail.logger.Error("seek failed", "offset", offset, "whence", tail.Location.Whence, "err", err)
 			return
 		}
+		tail.logger.Info("seeked", "offset", offset, "whence", tail.Location.Whence)
 	}
 
 	tail.openReader()
 	select {
-	case tail.Lines <- &Line{NotifyType: NewFileNotify, Filename: tail.Filename, Offset: offset, Time: time.Now(), OpenTime: tail.openTime}:
+	case tail.Lines <- &Line{NotifyType: NewFileNotify, Filename: tail.path(), Offset: offset, Time: time.Now(), OpenTime: tail.openTime, FileInfo: tail.fileInfo}:
 	case <-tail.Ctx.Done():
 		return
 	}
@@ -299,13 +380,13 @@ func (tail *Tail) tailFileSync() {
 			err := tail.waitForChanges(tail.Ctx)
 			if err != nil {
 				if err != ErrStop {
-					log.Printf("tail.waitForChanges() err: %s", err)
+					tail.logger.Error("waiting for changes failed", "err", err)
 				}
 				return
 			}
 		} else if err != nil {
 			// non-EOF error
-			log.Printf("Error reading %s: %s", tail.Filename, err)
+			tail.logger.Error("read failed", "err", err)
 			tail.Cancel()
 			return
 		}
@@ -321,7 +402,7 @@ func (tail *Tail) tailFileSync() {
 func (tail *Tail) readSend() error {
 	offset, err := tail.tell()
 	if err != nil {
-		log.Printf("tail.tell() err: %s", err)
+		tail.logger.Error("tell failed", "err", err)
 		return err
 	}
 
@@ -331,7 +412,7 @@ func (tail *Tail) readSend() error {
 	if err == nil {
 		err = tail.sendLine(line)
 		if err != nil {
-			log.Printf("tail.sendLine() err: %s", err)
+			tail.logger.Error("send line failed", "err", err)
 			return err
 		}
 		return nil
@@ -346,7 +427,7 @@ func (tail *Tail) readSend() error {
 		// it's not followed by a newline; seems a fair trade here
 		err := tail.seekTo(SeekInfo{Offset: offset, Whence: 0})
 		if err != nil {
-			log.Printf("tail.seekTo() err: %s", err)
+			tail.logger.Error("seek failed", "offset", offset, "err", err)
 			return err
 		}
 	}
@@ -375,38 +456,29 @@ func (tail *Tail) waitForChanges(ctx context.Context) error {
 				return err
 			}
 			select {
-			case tail.Lines <- &Line{NotifyType: TickerNotify, Time: time.Now(), Filename: tail.Filename, OpenTime: tail.openTime, Offset: offset}:
+			case tail.Lines <- &Line{NotifyType: TickerNotify, Time: time.Now(), Filename: tail.path(), OpenTime: tail.openTime, Offset: offset}:
 			case <-ctx.Done():
 				return nil
 			}
 			continue
-		case mode := <-tail.changes.Modified:
-			switch mode {
-			case watch.None, watch.Modified:
-				return nil
-			case watch.Rotated:
-				if err := tail.readSendAll(); err != nil {
-					return err
-				}
-				if tail.ReOpen {
-					log.Printf("Rotated event file %s. Re-opening ...", tail.Filename)
-					tail.changes = nil
-					if err := tail.reopen(ctx); err != nil {
-						return err
-					}
-					log.Printf("Successfully reopened %s", tail.Filename)
-					tail.openReader()
-					select {
-					case tail.Lines <- &Line{NotifyType: NewFileNotify, Filename: tail.Filename, Offset: 0, Time: time.Now(), OpenTime: tail.openTime}:
-					case <-ctx.Done():
-					}
-					return nil
-				}
+		case ev, ok := <-tail.changes.Events:
+			if !ok { // the watcher has stopped
 				tail.changes = nil
-				log.Printf("Stopping tail as file no longer exists: %s", tail.Filename)
-				return ErrStop
+				return nil
+			}
+			switch {
+			case ev.Kind == watch.None, ev.Kind == watch.Modified, ev.Kind == watch.PermissionChanged:
+				return nil
+			case ev.Kind == watch.Truncated:
+				tail.logger.Info("file truncated", "old_size", ev.OldSize, "new_size", ev.NewSize)
+				return tail.rotated(ctx)
+			case ev.Kind.Rotated():
+				tail.logger.Debug("file rotated", "event", ev.Kind.String(), "old_inode", ev.OldInode, "new_inode", ev.NewInode)
+				return tail.rotated(ctx)
+			case ev.Kind.Removed():
+				return tail.removed(ctx, ev)
 			default:
-				log.Printf("tail.changes.Modified: mode:%v", mode)
+				tail.logger.Warn("unknown file change", "event", ev.Kind.String())
 				if err := tail.readSendAll(); err != nil {
 					return err
 				}
@@ -418,6 +490,57 @@ func (tail *Tail) waitForChanges(ctx context.Context) error {
 	}
 }
 
+// rotated reads the rest of the file and reopens it if ReOpen.
+func (tail *Tail) rotated(ctx context.Context) error {
+	if err := tail.readSendAll(); err != nil {
+		return err
+	}
+	if tail.ReOpen {
+		tail.logger.Info("file rotated. reopening")
+		tail.changes = nil
+		if err := tail.reopen(ctx); err != nil {
+			return err
+		}
+		tail.logger.Info("reopened")
+		tail.openReader()
+		select {
+		case tail.Lines <- &Line{NotifyType: NewFileNotify, Filename: tail.path(), Offset: 0, Time: time.Now(), OpenTime: tail.openTime, FileInfo: tail.fileInfo}:
+		case <-ctx.Done():
+		}
+		return nil
+	}
+	tail.changes = nil
+	tail.logger.Info("stopping tail as the file no longer exists")
+	return ErrStop
+}
+
+// removed applies RemovePolicy to a deleted, moved or unmounted file.
+func (tail *Tail) removed(ctx context.Context, ev watch.Event) error {
+	tail.logger.Info("file removed", "event", ev.Kind.String(), "size", ev.OldSize, "policy", tail.RemovePolicy)
+	switch tail.RemovePolicy {
+	case RemoveStop:
+	case RemoveDrain:
+		if err := tail.readSendAll(); err != nil {
+			return err
+		}
+	default:
+		return tail.rotated(ctx)
+	}
+	tail.changes = nil
+	tail.mu.Lock()
+	tail.err = ErrRemoved
+	tail.mu.Unlock()
+	return ErrStop
+}
+
+// Err returns the reason the tail stopped by itself, e.g. ErrRemoved.
+// It is valid after Ctx is done.
+func (tail *Tail) Err() error {
+	tail.mu.RLock()
+	defer tail.mu.RUnlock()
+	return tail.err
+}
+
 func (tail *Tail) readSendAll() error {
 	for {
 		err := tail.readSend()
@@ -433,12 +556,15 @@ func (tail *Tail) readSendAll() error {
 
 func (tail *Tail) openReader() {
 	tail.setReader(tail.getFile())
-	fi, err := os.Stat(tail.Filename)
+	if tail.dec != nil {
+		tail.dec.sniff(tail.getFile())
+	}
+	fi, err := tail.getFile().Stat()
 	if err != nil {
-		tail.openTime = time.Now()
+		tail.openTime, tail.fileInfo = time.Now(), nil
 		return
 	}
-	tail.openTime = fi.ModTime()
+	tail.openTime, tail.fileInfo = fi.ModTime(), fi
 }
 
 func (tail *Tail) seekEnd() error {
@@ -464,8 +590,13 @@ func (tail *Tail) sendLine(line []byte) error {
 		//tail.Kill(err)
 		return err
 	}
+	l := &Line{NotifyType: NewLineNotify, Text: line, Time: now, Filename: tail.path(), OpenTime: tail.openTime, Offset: offset}
+	if tail.dec != nil {
+		l.Raw = line
+		l.Text, l.Err = tail.dec.decode(line)
+	}
 	select {
-	case tail.Lines <- &Line{NotifyType: NewLineNotify, Text: line, Time: now, Filename: tail.Filename, OpenTime: tail.openTime, Offset: offset}:
+	case tail.Lines <- l:
 	case <-tail.Ctx.Done():
 	}
 	return nil
@@ -475,8 +606,10 @@ func (tail *Tail) sendLine(line []byte) error {
 // meant to be invoked from a process's exit handler. Linux kernel may not
 // automatically remove inotify watches after the process exits.
 func (tail *Tail) Cleanup(ctx context.Context) {
-	if tail.tracker != nil {
-		tail.tracker.CloseAll(ctx)
+	if c, ok := tail.watcher.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			tail.logger.Warn("error closing watcher", "err", err)
+		}
 	}
 	tail.Cancel()
 }
@@ -0,0 +1,118 @@
+package tail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRemovePolicy(t *testing.T) {
+	for _, e := range []struct {
+		policy RemovePolicy
+		poll   bool
+		lines  []string
+	}{
+		{RemoveDrain, true, []string{"a\n", "b\n"}},
+		{RemoveStop, true, []string{"a\n"}},
+		{RemoveDrain, false, []string{"a\n", "b\n"}},
+		{RemoveStop, false, []string{"a\n"}},
+	} {
+		name := filepath.Join(t.TempDir(), "a.log")
+		if err := os.WriteFile(name, []byte("a\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		tl, err := TailFile(ctx, name, Config{
+			Poll: e.poll, PollInterval: 10 * time.Millisecond, MaxPollInterval: 10 * time.Millisecond,
+			StatInterval: 10 * time.Millisecond, ReOpenDelay: 50 * time.Millisecond,
+			RemovePolicy: e.policy, LinesChanSize: 10,
+		}, make(chan bool, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for len(lines) < 1 {
+			if l := <-tl.Lines; l.NotifyType == NewLineNotify {
+				lines = append(lines, string(l.Text))
+			}
+		}
+		if e.policy == RemoveDrain {
+			// 削除前の追記は読み込まれる
+			f, _ := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0644)
+			f.WriteString("b\n")
+			f.Close()
+		}
+		os.Remove(name)
+		<-tl.Ctx.Done()
+		for len(tl.Lines) > 0 {
+			if l := <-tl.Lines; l.NotifyType == NewLineNotify {
+				lines = append(lines, string(l.Text))
+			}
+		}
+		if tl.Err() != ErrRemoved {
+			t.Errorf("%s poll=%v: Err()=%v, want %v", e.policy, e.poll, tl.Err(), ErrRemoved)
+		}
+		if len(lines) != len(e.lines) {
+			t.Errorf("%s poll=%v: lines=%q, want %q", e.policy, e.poll, lines, e.lines)
+		}
+		cancel()
+	}
+}
+
+func TestFollowSymlink(t *testing.T) {
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	a, b, link := filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log"), filepath.Join(dir, "current.log")
+	os.WriteFile(a, []byte("a1\n"), 0644)
+	os.Symlink(a, link)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tl, err := TailFile(ctx, link, Config{
+		Poll: true, PollInterval: 10 * time.Millisecond, MaxPollInterval: 10 * time.Millisecond,
+		ReOpen: true, FollowSymlink: true, LinesChanSize: 10,
+	}, make(chan bool, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() *Line {
+		for {
+			select {
+			case l := <-tl.Lines:
+				if l.NotifyType != TickerNotify {
+					return l
+				}
+			case <-ctx.Done():
+				t.Fatal("timeout")
+			}
+		}
+	}
+	if l := next(); l.NotifyType != NewFileNotify || l.Filename != a {
+		t.Fatalf("first line=%+v, want NewFileNotify of %s", l, a)
+	}
+	next()
+
+	// 付け替え前の追記は古いリンク先から読み込む
+	f, _ := os.OpenFile(a, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("a2\n")
+	f.Close()
+	os.WriteFile(b, []byte("b1\n"), 0644)
+	os.Symlink(b, link+".tmp")
+	os.Rename(link+".tmp", link)
+
+	expect := []struct {
+		typ      int
+		text     string
+		filename string
+	}{
+		{NewLineNotify, "a2\n", a},
+		{NewFileNotify, "", b},
+		{NewLineNotify, "b1\n", b},
+	}
+	for i, e := range expect {
+		l := next()
+		if l.NotifyType != e.typ || string(l.Text) != e.text || l.Filename != e.filename {
+			t.Errorf("%d: line=%d %q %s, want %d %q %s", i, l.NotifyType, l.Text, l.Filename, e.typ, e.text, e.filename)
+		}
+	}
+}
@@ -0,0 +1,430 @@
+package tailex
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// composites 他の変換の組み合わせになる変換 (POSIXロケール)
+var composites = map[byte]string{
+	'c': "%a %b %e %H:%M:%S %Y",
+	'D': "%m/%d/%y",
+	'F': "%Y-%m-%d",
+	'r': "%I:%M:%S %p",
+	'R': "%H:%M",
+	'T': "%H:%M:%S",
+	'x': "%m/%d/%y",
+	'X': "%H:%M:%S",
+}
+
+// expand compositesを展開する
+func expand(format string) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] == '%' && i+1 < len(format) {
+			if s, ok := composites[format[i+1]]; ok {
+				b.WriteString(s)
+				i++
+				continue
+			}
+			b.WriteString(format[i : i+2])
+			i++
+			continue
+		}
+		b.WriteByte(format[i])
+	}
+	return b.String()
+}
+
+// Strftime strftime(3)と同じ変換でtを文字列にする (POSIXロケール、tのタイムゾーン)
+// %N はftailer独自の変換でtが今日から何日前か。未知の変換はそのまま残す
+func Strftime(format string, t time.Time) string {
+	format = expand(format)
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		b.WriteString(conv(format[i], t))
+	}
+	return b.String()
+}
+
+func conv(c byte, t time.Time) string {
+	switch c {
+	case 'a':
+		return t.Format("Mon")
+	case 'A':
+		return t.Format("Monday")
+	case 'b', 'h':
+		return t.Format("Jan")
+	case 'B':
+		return t.Format("January")
+	case 'C':
+		return fmt.Sprintf("%02d", t.Year()/100)
+	case 'd':
+		return fmt.Sprintf("%02d", t.Day())
+	case 'e':
+		return fmt.Sprintf("%2d", t.Day())
+	case 'g':
+		y, _ := t.ISOWeek()
+		return fmt.Sprintf("%02d", y%100)
+	case 'G':
+		y, _ := t.ISOWeek()
+		return fmt.Sprintf("%04d", y)
+	case 'H':
+		return fmt.Sprintf("%02d", t.Hour())
+	case 'I':
+		return fmt.Sprintf("%02d", hour12(t.Hour()))
+	case 'j':
+		return fmt.Sprintf("%03d", t.YearDay())
+	case 'k':
+		return fmt.Sprintf("%2d", t.Hour())
+	case 'l':
+		return fmt.Sprintf("%2d", hour12(t.Hour()))
+	case 'm':
+		return fmt.Sprintf("%02d", t.Month())
+	case 'M':
+		return fmt.Sprintf("%02d", t.Minute())
+	case 'n':
+		return "\n"
+	case 'N':
+		return strconv.Itoa(daysAgo(t))
+	case 'p':
+		return t.Format("PM")
+	case 'P':
+		return t.Format("pm")
+	case 's':
+		return strconv.FormatInt(t.Unix(), 10)
+	case 'S':
+		return fmt.Sprintf("%02d", t.Second())
+	case 't':
+		return "\t"
+	case 'u':
+		return strconv.Itoa((int(t.Weekday())+6)%7 + 1)
+	case 'U':
+		return fmt.Sprintf("%02d", (t.YearDay()+6-int(t.Weekday()))/7)
+	case 'V':
+		_, w := t.ISOWeek()
+		return fmt.Sprintf("%02d", w)
+	case 'w':
+		return strconv.Itoa(int(t.Weekday()))
+	case 'W':
+		return fmt.Sprintf("%02d", (t.YearDay()+6-(int(t.Weekday())+6)%7)/7)
+	case 'y':
+		return fmt.Sprintf("%02d", t.Year()%100)
+	case 'Y':
+		return fmt.Sprintf("%04d", t.Year())
+	case 'z':
+		return t.Format("-0700")
+	case 'Z':
+		return t.Format("MST")
+	case '%':
+		return "%"
+	}
+	return "%" + string(c)
+}
+
+func hour12(h int) int {
+	if h%12 == 0 {
+		return 12
+	}
+	return h % 12
+}
+
+// daysAgo tの日付がtのタイムゾーンの今日から何日前か
+func daysAgo(t time.Time) int {
+	now := time.Now().In(t.Location())
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return int(today.Sub(day).Hours() / 24)
+}
+
+// patterns ParsePathで各変換に一致する正規表現
+var patterns = map[byte]string{
+	'a': `[A-Za-z]{3}`, 'A': `[A-Za-z]+`, 'b': `[A-Za-z]{3}`, 'h': `[A-Za-z]{3}`, 'B': `[A-Za-z]+`,
+	'C': `[0-9]{2}`, 'd': `[0-9]{2}`, 'e': `[ 0-9][0-9]`, 'g': `[0-9]{2}`, 'G': `[0-9]{4}`,
+	'H': `[0-9]{2}`, 'I': `[0-9]{2}`, 'j': `[0-9]{3}`, 'k': `[ 0-9][0-9]`, 'l': `[ 0-9][0-9]`,
+	'm': `[0-9]{2}`, 'M': `[0-9]{2}`, 'N': `[0-9]+`, 'p': `[AaPp][Mm]`, 'P': `[AaPp][Mm]`,
+	's': `-?[0-9]+`, 'S': `[0-9]{2}`, 'u': `[1-7]`, 'U': `[0-9]{2}`, 'V': `[0-9]{2}`,
+	'w': `[0-6]`, 'W': `[0-9]{2}`, 'y': `[0-9]{2}`, 'Y': `[0-9]{4}`, 'z': `[+-][0-9]{4}`,
+	'Z': `[A-Za-z]+|[+-][0-9]{2,4}`,
+}
+
+// pathRegexp formatに一致する正規表現と各グループの変換
+// 変換以外の * ? はGlobSearchと同じく / 以外の任意の文字列、任意の1文字に一致する
+func pathRegexp(format string) (*regexp.Regexp, []byte, error) {
+	format = expand(format)
+	var b strings.Builder
+	var convs []byte
+	b.WriteByte('^')
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		switch {
+		case c == '*':
+			b.WriteString(`[^/]*`)
+		case c == '?':
+			b.WriteString(`[^/]`)
+		case c != '%' || i+1 == len(format):
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		default:
+			i++
+			c = format[i]
+			switch c {
+			case '%':
+				b.WriteByte('%')
+			case 'n':
+				b.WriteString(`\n`)
+			case 't':
+				b.WriteString(`\t`)
+			default:
+				p, ok := patterns[c]
+				if !ok {
+					return nil, nil, fmt.Errorf("strftime: unknown conversion %%%c in %q", c, format)
+				}
+				b.WriteString("(" + p + ")")
+				convs = append(convs, c)
+			}
+		}
+	}
+	b.WriteByte('$')
+	re, err := regexp.Compile(b.String())
+	return re, convs, err
+}
+
+// ParsePath Strftime(format, t)で作られたpathからtを求める
+// 時刻の一部しか含まない場合、年はlocの今年、それ以外は最小の値とする
+// %z, %s を含まない場合はlocの時刻 (nilはtime.Local)
+func ParsePath(format, path string, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	re, convs, err := pathRegexp(format)
+	if err != nil {
+		return time.Time{}, err
+	}
+	m := re.FindStringSubmatch(path)
+	if m == nil {
+		return time.Time{}, fmt.Errorf("strftime: %q does not match %q", path, format)
+	}
+	f := fields{month: -1, day: -1, yday: -1, wday: -1, weekU: -1, weekW: -1, weekV: -1, year: -1, century: -1, yy: -1, isoYear: -1, daysAgo: -1}
+	for i, c := range convs {
+		if err := f.set(c, strings.TrimSpace(m[i+1])); err != nil {
+			return time.Time{}, fmt.Errorf("strftime: %%%c in %q: %s", c, path, err)
+		}
+	}
+	return f.time(loc)
+}
+
+// fields ParsePathで取り出した値 (-1は無し)
+type fields struct {
+	year, century, yy, isoYear int
+	month, day, yday           int
+	weekU, weekW, weekV, wday  int
+	hour, min, sec             int
+	pm, hasPM                  bool
+	unix                       *int64
+	zone                       *time.Location
+	daysAgo                    int
+}
+
+var (
+	shortMonths = map[string]int{}
+	longMonths  = map[string]int{}
+	shortDays   = map[string]int{}
+	longDays    = map[string]int{}
+)
+
+func init() {
+	for m := time.January; m <= time.December; m++ {
+		shortMonths[strings.ToLower(m.String()[:3])] = int(m)
+		longMonths[strings.ToLower(m.String())] = int(m)
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		shortDays[strings.ToLower(d.String()[:3])] = int(d)
+		longDays[strings.ToLower(d.String())] = int(d)
+	}
+}
+
+func (f *fields) set(c byte, v string) error {
+	switch c {
+	case 'a', 'A':
+		names := shortDays
+		if c == 'A' {
+			names = longDays
+		}
+		d, ok := names[strings.ToLower(v)]
+		if !ok {
+			return fmt.Errorf("unknown weekday %q", v)
+		}
+		f.wday = d
+		return nil
+	case 'b', 'h', 'B':
+		names := shortMonths
+		if c == 'B' {
+			names = longMonths
+		}
+		m, ok := names[strings.ToLower(v)]
+		if !ok {
+			return fmt.Errorf("unknown month %q", v)
+		}
+		f.month = m
+		return nil
+	case 'p', 'P':
+		f.hasPM, f.pm = true, strings.EqualFold(v, "pm")
+		return nil
+	case 's':
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.unix = &n
+		return nil
+	case 'z':
+		t, err := time.Parse("-0700", v)
+		if err != nil {
+			return err
+		}
+		f.zone = t.Location()
+		return nil
+	case 'Z': // 略称からはタイムゾーンを決められないので数値の場合のみ使う
+		if t, err := time.Parse("-07", v); err == nil {
+			f.zone = t.Location()
+		} else if t, err := time.Parse("-0700", v); err == nil {
+			f.zone = t.Location()
+		}
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	ranges := map[byte][2]int{ // 最小, 最大
+		'C': {0, 99}, 'd': {1, 31}, 'e': {1, 31}, 'g': {0, 99}, 'G': {0, 9999}, 'H': {0, 23}, 'I': {1, 12},
+		'j': {1, 366}, 'k': {0, 23}, 'l': {1, 12}, 'm': {1, 12}, 'M': {0, 59}, 'N': {0, 1 << 30}, 'S': {0, 60},
+		'u': {1, 7}, 'U': {0, 53}, 'V': {1, 53}, 'w': {0, 6}, 'W': {0, 53}, 'y': {0, 99}, 'Y': {0, 9999},
+	}
+	r := ranges[c]
+	if n < r[0] || n > r[1] {
+		return fmt.Errorf("%d is out of range", n)
+	}
+	switch c {
+	case 'C':
+		f.century = n
+	case 'd', 'e':
+		f.day = n
+	case 'g':
+		if f.isoYear < 0 {
+			f.isoYear = 2000 + n
+		}
+	case 'G':
+		f.isoYear = n
+	case 'H', 'k', 'I', 'l':
+		f.hour = n
+	case 'j':
+		f.yday = n
+	case 'm':
+		f.month = n
+	case 'M':
+		f.min = n
+	case 'N':
+		f.daysAgo = n
+	case 'S':
+		f.sec = n
+	case 'u':
+		f.wday = n % 7
+	case 'U':
+		f.weekU = n
+	case 'V':
+		f.weekV = n
+	case 'w':
+		f.wday = n
+	case 'W':
+		f.weekW = n
+	case 'y':
+		f.yy = n
+	case 'Y':
+		f.year = n
+	}
+	return nil
+}
+
+func (f *fields) time(loc *time.Location) (time.Time, error) {
+	if f.zone != nil {
+		loc = f.zone
+	}
+	if f.unix != nil {
+		return time.Unix(*f.unix, 0).In(loc), nil
+	}
+	hour := f.hour
+	if f.hasPM {
+		hour %= 12
+		if f.pm {
+			hour += 12
+		}
+	}
+	year := f.year
+	switch {
+	case year >= 0:
+	case f.yy >= 0 && f.century >= 0:
+		year = f.century*100 + f.yy
+	case f.yy >= 0: // POSIX: 69-99は1900年代
+		year = 2000 + f.yy
+		if f.yy >= 69 {
+			year = 1900 + f.yy
+		}
+	case f.isoYear >= 0:
+		year = f.isoYear
+	default:
+		year = time.Now().In(loc).Year()
+	}
+	wday := f.wday
+	var date time.Time
+	switch {
+	case f.month >= 0 || f.day >= 0:
+		month, day := max(f.month, 1), max(f.day, 1)
+		date = time.Date(year, time.Month(month), day, 0, 0, 0, 0, loc)
+		if date.Day() != day {
+			return time.Time{}, fmt.Errorf("strftime: invalid date %04d-%02d-%02d", year, month, day)
+		}
+	case f.yday >= 0:
+		date = time.Date(year, 1, f.yday, 0, 0, 0, 0, loc)
+		if date.Year() != year {
+			return time.Time{}, fmt.Errorf("strftime: invalid day of year %d in %d", f.yday, year)
+		}
+	case f.weekU >= 0 || f.weekW >= 0:
+		// 週の最初の曜日 (%Uは日曜、%Wは月曜) から数えた週番号。最初のその曜日より前は第0週
+		first, week := time.Sunday, f.weekU
+		if f.weekU < 0 {
+			first, week = time.Monday, f.weekW
+		}
+		if wday < 0 {
+			wday = int(first)
+		}
+		jan1 := time.Date(year, 1, 1, 0, 0, 0, 0, loc)
+		start := (int(first) - int(jan1.Weekday()) + 7) % 7 // 第1週の初日
+		date = jan1.AddDate(0, 0, start+(week-1)*7+(wday-int(first)+7)%7)
+	case f.weekV >= 0:
+		isoYear := f.isoYear
+		if isoYear < 0 {
+			isoYear = year
+		}
+		if wday < 0 {
+			wday = int(time.Monday)
+		}
+		jan4 := time.Date(isoYear, 1, 4, 0, 0, 0, 0, loc)
+		monday := jan4.AddDate(0, 0, -((int(jan4.Weekday()) + 6) % 7))
+		date = monday.AddDate(0, 0, (f.weekV-1)*7+(wday+6)%7)
+	case f.daysAgo >= 0:
+		now := time.Now().In(loc)
+		date = time.Date(now.Year(), now.Month(), now.Day()-f.daysAgo, 0, 0, 0, 0, loc)
+	default:
+		date = time.Date(year, 1, 1, 0, 0, 0, 0, loc)
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), hour, f.min, f.sec, 0, loc), nil
+}
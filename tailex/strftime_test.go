@@ -0,0 +1,71 @@
+package tailex
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStrftime(t *testing.T) {
+	jst := time.FixedZone("JST", 9*60*60)
+	tm := time.Date(2024, 3, 5, 14, 7, 9, 0, jst) // 火曜日
+	for _, e := range []struct{ format, want string }{
+		{"%Y%m%d/%H%M%S.log", "20240305/140709.log"},
+		{"%y-%j-%%", "24-065-%"},
+		{"%a %A %b %h %B", "Tue Tuesday Mar Mar March"},
+		{"%e|%k|%l|%I%p|%P", " 5|14| 2|02PM|pm"},
+		{"%U %W %V %G %g %u %w", "09 10 10 2024 24 2 2"},
+		{"%F %T %D %R %r", "2024-03-05 14:07:09 03/05/24 14:07 02:07:09 PM"},
+		{"%c", "Tue Mar  5 14:07:09 2024"},
+		{"%s %z %Z %C", "1709615229 +0900 JST 20"},
+		{"%q%", "%q%"},
+	} {
+		if got := Strftime(e.format, tm); got != e.want {
+			t.Errorf("%q: got %q, want %q", e.format, got, e.want)
+		}
+	}
+	if got := Time2Path("%N", time.Now().AddDate(0, 0, -3)); got != "3" {
+		t.Errorf("%%N: got %q", got)
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	jst := time.FixedZone("JST", 9*60*60)
+	for _, e := range []struct {
+		format string
+		want   time.Time
+	}{
+		{"/var/log/%Y%m%d/%H%M.log", time.Date(2024, 3, 5, 14, 7, 0, 0, jst)},
+		{"log.%y%j", time.Date(2024, 12, 31, 0, 0, 0, 0, jst)},
+		{"%d-%b-%Y_%I%p.log", time.Date(2024, 1, 9, 23, 0, 0, 0, jst)},
+		{"%B_%e_%Y_%l%P", time.Date(2024, 12, 1, 0, 0, 0, 0, jst)},
+		{"%Y-W%W-%a", time.Date(2024, 3, 5, 0, 0, 0, 0, jst)},
+		{"%Y-U%U-%u", time.Date(2023, 1, 1, 0, 0, 0, 0, jst)},
+		{"%G-W%V-%u", time.Date(2021, 1, 3, 0, 0, 0, 0, jst)},
+		{"%s.log", time.Date(2024, 3, 5, 14, 7, 9, 0, jst)},
+		{"%F_%T%z", time.Date(2024, 3, 5, 14, 7, 9, 0, time.FixedZone("", -5*60*60))},
+		{"app-%Y%m%d-*.log", time.Date(2024, 3, 5, 0, 0, 0, 0, jst)},
+	} {
+		path := Strftime(e.format, e.want)
+		if e.format == "app-%Y%m%d-*.log" {
+			path = "app-20240305-host1.log"
+		}
+		got, err := ParsePath(e.format, path, jst)
+		if err != nil {
+			t.Errorf("%q %q: %s", e.format, path, err)
+			continue
+		}
+		if !got.Equal(e.want) {
+			t.Errorf("%q %q: got %s, want %s", e.format, path, got, e.want)
+		}
+	}
+	for _, e := range []struct{ format, path string }{
+		{"%Y%m%d.log", "20240230.log"},
+		{"%Y%m%d.log", "2024030.log"},
+		{"%H.log", "24.log"},
+		{"%Q.log", "x.log"},
+	} {
+		if got, err := ParsePath(e.format, e.path, jst); err == nil {
+			t.Errorf("%q %q: no error (%s)", e.format, e.path, got)
+		}
+	}
+}
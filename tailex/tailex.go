@@ -1,10 +1,10 @@
 package tailex
 
 import (
+	"cmp"
 	"context"
 	"errors"
-	"fmt"
-	"log"
+	"log/slog"
 	"path/filepath"
 	"strings"
 	"time"
@@ -19,11 +19,12 @@ type Config struct {
 	Path string
 
 	// Cronolog
-	PathFmt       string        // cronologなどのpathに日付が入る場合
-	Time          time.Time     // start日時
-	RotatePeriod  time.Duration // ログローテーション間隔
-	Delay         time.Duration // 切り替えwait
-	LinesChanSize int           // Lines channel size
+	PathFmt       string         // cronologなどのpathに日付が入る場合
+	Time          time.Time      // start日時
+	RotatePeriod  time.Duration  // ログローテーション間隔
+	PathLocation  *time.Location // PathFmtの日付のタイムゾーン (nilはtime.Local)
+	Delay         time.Duration  // 切り替えwait
+	LinesChanSize int            // Lines channel size
 	//Pos           *core.Position
 	NoSeek bool // 有効にするとポジション情報がない時に先頭から読み直す
 
@@ -62,26 +63,42 @@ func Truncate(t time.Time, d time.Duration) time.Time {
 	return t.Truncate(d)
 }
 
+// InLocation PathFmtのタイムゾーンのt
+func (c Config) InLocation(t time.Time) time.Time {
+	if c.PathLocation == nil {
+		return t.In(time.Local)
+	}
+	return t.In(c.PathLocation)
+}
+
 func NewTailEx(ctx context.Context, config Config, w chan bool) *TailEx {
 	c := &TailEx{
 		Config:    config,
 		WorkLimit: w,
 		Lines:     make(chan *tail.Line, config.LinesChanSize),
-		timeSlice: Truncate(config.Time, config.RotatePeriod),
+		timeSlice: Truncate(config.InLocation(config.Time), config.RotatePeriod),
 		//FileInfo:  make(chan FileInfo),
 	}
-	log.Printf("init config.Time:%s -> timeSlice:%s", config.Time, Truncate(config.Time, config.RotatePeriod)) //TODO: test
+	c.logger().Debug("init timeSlice", "time", config.Time, "time_slice", c.timeSlice)
 
 	go c.tailFileSyncLoop(ctx)
 	return c
 }
 
+func (c *TailEx) logger() *slog.Logger {
+	l := cmp.Or(c.Logger, slog.Default())
+	if c.PathFmt != "" {
+		return l.With("path_fmt", c.PathFmt)
+	}
+	return l.With("path", c.Path)
+}
+
 func (c *TailEx) tailFileSyncLoop(ctx context.Context) {
 	for {
 		// ファイルを開く
 		if err := c.newOpen(ctx); err != nil {
 			if err != context.Canceled {
-				log.Printf("tailFileSyncLoop newOpen err:%s", err)
+				c.logger().Error("open failed", "err", err)
 			}
 			return
 		}
@@ -90,12 +107,15 @@ func (c *TailEx) tailFileSyncLoop(ctx context.Context) {
 		tailFileSyncErr := c.tailFileSync(ctx)
 
 		c.tail.Cleanup(ctx) //  古い方をcleanup
-		//log.Printf("TailEx end tail.cleanup %s:%s", c.Path, c.timeSlice)
+		c.logger().Debug("end tail.Cleanup", "time_slice", c.timeSlice)
 		c.tail = nil
 		//c.Pos.Offset = 0
 		c.Location = nil
 
 		if tailFileSyncErr != nil {
+			if tailFileSyncErr == tail.ErrRemoved {
+				close(c.Lines) // RemovePolicyによる停止
+			}
 			return
 		}
 		c.timeSlice = c.timeSlice.Add(c.RotatePeriod)
@@ -120,7 +140,7 @@ func (c *TailEx) GlobSearchLoop(ctx context.Context, pathFmt string) (string, er
 			return "", err // その他のエラー
 		}
 		if firstFlag {
-			log.Printf("%s:GlobSearch s:'%s', %s", err, globPath, pathFmt)
+			c.logger().Info("waiting for the file", "glob", globPath, "err", err)
 			firstFlag = false
 		}
 		select {
@@ -136,9 +156,9 @@ func (c *TailEx) GlobSearchLoop(ctx context.Context, pathFmt string) (string, er
 			return "", ctx.Err()
 		}
 		// timeSliceが過去なら進める
-		if Truncate(time.Now(), c.RotatePeriod).Sub(c.timeSlice) > 0 {
+		if Truncate(c.InLocation(time.Now()), c.RotatePeriod).Sub(c.timeSlice) > 0 {
 			next := c.timeSlice.Add(c.RotatePeriod)
-			log.Printf("GlobSearchLoop %s: add timeSlice:%s -> %v", pathFmt, c.timeSlice, next)
+			c.logger().Info("GlobSearchLoop: advance timeSlice", "time_slice", c.timeSlice, "next", next)
 			c.timeSlice = next
 		}
 	}
@@ -158,13 +178,12 @@ func (c *TailEx) tailExFile(ctx context.Context) error {
 			return err
 		}
 	}
-	log.Printf("Start tail.TailFile(%s) location:%# v", c.filePath, c.Location) //TODO: test
+	c.logger().Debug("start tail.TailFile", "file", c.filePath, "location", c.Location)
 	t, err := tail.TailFile(ctx, c.filePath, c.Config.Config, c.WorkLimit)
 	if err != nil {
 		return err
 	}
 	c.tail = t
-	//c.Lines <- &tail.Line{NotifyType: NewFileNotify, Time: time.Now()} //TODO: Testtest
 	return err
 }
 
@@ -193,24 +212,29 @@ func (c *TailEx) tailFileSync(ctx context.Context) error {
 		if c.old {
 			nextwait = 0
 		}
-		log.Printf("set timer nextwait:%v, timeSlice:%v, old:%v", nextwait, c.timeSlice, c.old) //TODO: test
+		c.logger().Debug("set timer", "nextwait", nextwait, "time_slice", c.timeSlice, "old", c.old)
 		nextFileTime = time.Now().Add(nextwait)
 	}
+	stopped := c.tail.Ctx.Done()
 	for {
 		select {
 		case <-ctx.Done():
 			// キャンセル処理
-			//log.Printf("tailFileSync ctx Done. %s:%s, %v", c.filePath, c.timeSlice, ctx.Err()) //TODO: test
 			return ctx.Err()
+		case <-stopped:
+			stopped = nil
+			if err := c.tail.Err(); err != nil {
+				c.flushLines(ctx)
+				return err
+			}
 		case l := <-c.tail.Lines:
-			//log.Printf("l:%v,%s", l.Time, l.Text) //TODO:test
 			if c.old {
 				l.Time = c.timeSlice.Add(c.RotatePeriod - 1*time.Second)
 			}
 			if l.NotifyType == tail.TickerNotify {
 				if !nextFileTime.IsZero() && !c.old && l.Time.Sub(nextFileTime) >= c.Delay {
 					// cronolog のファイル更新
-					log.Printf("set time.After:%v, l.Time:%v, old:%v", c.Delay, l.Time, c.old) //TODO: test
+					c.logger().Debug("switch to the next file", "delay", c.Delay, "time", l.Time, "old", c.old)
 					return nil
 				}
 			}
@@ -227,16 +251,33 @@ func (c *TailEx) tailFileSync(ctx context.Context) error {
 		}
 	}
 }
+
+// flushLines 停止したtailに残っている行を送る
+func (c *TailEx) flushLines(ctx context.Context) {
+	for {
+		select {
+		case l := <-c.tail.Lines:
+			select {
+			case c.Lines <- l:
+			case <-ctx.Done():
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
 func (c *TailEx) newOpen(ctx context.Context) error {
 	err := c.tailExFile(ctx) // 新しいファイルを開く
 	if err != nil {
 		if err != context.Canceled {
-			log.Printf("TailEx.tailExFile file:%s, err:%s", c.filePath, err)
+			c.logger().Error("tailExFile failed", "file", c.filePath, "err", err)
 		}
 		c.Stop()
 		return err
 	}
-	log.Printf("Tail Open file %s", c.filePath)
+	c.logger().Info("tail open file", "file", c.filePath)
 	return nil
 }
 
@@ -249,25 +290,10 @@ func Path2Name(p string) string {
 	return p
 }
 
-// Pathの日付フォーマットに日付を適用
-// %N は日付ではなくN日前の数値
+// Pathの日付フォーマットに日付を適用 (tのタイムゾーン)
+// 変換はStrftimeを参照。%N は日付ではなくN日前の数値
 func Time2Path(p string, t time.Time) string {
-	p = strings.Replace(p, "%Y", fmt.Sprintf("%04d", t.Year()), -1)
-	p = strings.Replace(p, "%y", fmt.Sprintf("%02d", t.Year()%100), -1)
-	p = strings.Replace(p, "%m", fmt.Sprintf("%02d", t.Month()), -1)
-	p = strings.Replace(p, "%d", fmt.Sprintf("%02d", t.Day()), -1)
-	p = strings.Replace(p, "%H", fmt.Sprintf("%02d", t.Hour()), -1)
-	p = strings.Replace(p, "%M", fmt.Sprintf("%02d", t.Minute()), -1)
-	p = strings.Replace(p, "%S", fmt.Sprintf("%02d", t.Second()), -1)
-	if strings.Index(p, "%N") == -1 { // 数値指定がなければ終わる
-		return p
-	}
-	now := time.Now()
-	now = now.Truncate(time.Hour).Add(-time.Duration(now.Hour()) * time.Hour)
-	num := int((now.Sub(t) / 24).Hours())
-	p = strings.Replace(p, "%N", fmt.Sprintf("%d", num), -1)
-	return p
-
+	return Strftime(p, t)
 }
 
 func GlobSearch(globPath string) (string, error) {
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/masahide/ftailer/core"
+)
+
+// followInterval 新しい行と新しい .rec の確認間隔
+var followInterval = 500 * time.Millisecond
+
+// follow 書き込み中の .rec ファイルを tail -f のように出力し続ける
+// 次の期間の .rec が作られるか、.fixed にリネームされたら次の .rec に移る
+func follow(db *core.DB, s *selector, w io.Writer) error {
+	var last time.Time
+	for {
+		f, err := waitRec(db, last)
+		if err != nil {
+			return err
+		}
+		if err = followFile(db, f, s, w); err != nil {
+			return err
+		}
+		last = f.Time
+	}
+}
+
+// waitRec afterより新しい .rec ファイルが見つかるまで待つ
+// afterがゼロの場合は最新の .rec
+func waitRec(db *core.DB, after time.Time) (core.DBFiles, error) {
+	first := true
+	for {
+		f, ok, err := nextRec(db, after)
+		if err != nil || ok {
+			return f, err
+		}
+		if first {
+			log.Printf("waiting for .rec file: %s/%s", db.Path, db.Name)
+			first = false
+		}
+		time.Sleep(followInterval)
+	}
+}
+
+func nextRec(db *core.DB, after time.Time) (core.DBFiles, bool, error) {
+	files, err := core.RecGlob(db)
+	if err != nil || len(files) == 0 {
+		return core.DBFiles{}, false, err
+	}
+	if after.IsZero() {
+		return files[len(files)-1], true, nil
+	}
+	for _, f := range files {
+		if f.Time.After(after) {
+			return f, true, nil
+		}
+	}
+	return core.DBFiles{}, false, nil
+}
+
+func followFile(db *core.DB, f core.DBFiles, s *selector, w io.Writer) error {
+	if _, err := os.Stat(f.Path); os.IsNotExist(err) {
+		return nil
+	}
+	fdb, err := core.FtailDBOpen(f.Path, 0660, Options, nil)
+	if err != nil {
+		// 作成直後でヘッダが書き込まれていない場合は少し待つ
+		time.Sleep(followInterval)
+		if fdb, err = core.FtailDBOpen(f.Path, 0660, Options, nil); err != nil {
+			log.Printf("err:%s", err)
+			return nil
+		}
+	}
+	defer func() {
+		if err := fdb.Close(); err != nil {
+			log.Printf("db.Close err:%s", err)
+		}
+	}()
+	log.Printf("follow db: %v meta:%v -------------", f.Path, fdb.Meta)
+	var rowErr error
+	for {
+		row, err := fdb.ReadNextRow()
+		if err == nil {
+			rowErr = nil
+			if !s.row(row) {
+				continue
+			}
+			if err = format(w, f.Path, row); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != io.EOF {
+			rowErr = err // 書き込み途中の行の可能性があるので閉じられるまで待つ
+		}
+		closed, next, cerr := followDone(db, f)
+		if cerr != nil {
+			return cerr
+		}
+		if closed || next {
+			// 閉じられた後に書き込まれた行を読み込んでから次へ
+			if err := drain(fdb, f.Path, s, w); err != nil {
+				log.Printf("readDB err:%s", err)
+			} else if closed && rowErr != nil {
+				log.Printf("readDB err:%s", rowErr)
+			}
+			return nil
+		}
+		time.Sleep(followInterval)
+	}
+}
+
+// followDone .rec が .fixed にリネームされたか、次の .rec が作られたか
+func followDone(db *core.DB, f core.DBFiles) (closed, next bool, err error) {
+	if _, err := os.Stat(f.Path); os.IsNotExist(err) {
+		return true, false, nil
+	}
+	_, next, err = nextRec(db, f.Time)
+	return false, next, err
+}
+
+func drain(fdb *core.FtailDB, path string, s *selector, w io.Writer) error {
+	for {
+		row, err := fdb.ReadNextRow()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !s.row(row) {
+			continue
+		}
+		if err = format(w, path, row); err != nil {
+			return err
+		}
+	}
+}
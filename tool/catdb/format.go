@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/masahide/ftailer/core"
+)
+
+// 出力フォーマット
+const (
+	FormatRaw   = "raw"   // 展開したテキストのみ
+	FormatJSONL = "jsonl" // 1行1JSONで行のメタデータと一緒に出力
+	FormatDebug = "debug" // 行の境界, ファイル上のオフセット, チェックサムとペイロードのダンプ
+)
+
+// formatter 1行をwに出力する
+type formatter func(w io.Writer, file string, row *core.Row) error
+
+func newFormatter(name string) (formatter, error) {
+	switch name {
+	case "", FormatRaw:
+		return formatRaw, nil
+	case FormatJSONL:
+		return formatJSONL, nil
+	case FormatDebug:
+		return formatDebug, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", name)
+}
+
+func formatRaw(w io.Writer, file string, row *core.Row) error {
+	_, err := row.WriteTo(w)
+	return err
+}
+
+type jsonPosition struct {
+	Name       string    `json:"name"`
+	CreateAt   time.Time `json:"create_at"`
+	Offset     int64     `json:"offset"`
+	HeadHash   string    `json:"head_hash,omitempty"`
+	HashLength int64     `json:"hash_length,omitempty"`
+}
+
+type jsonRow struct {
+	File       string                   `json:"file"`
+	RowOffset  *int64                   `json:"row_offset,omitempty"`
+	Time       time.Time                `json:"time"`
+	Pos        jsonPosition             `json:"pos"`
+	Compressed bool                     `json:"compressed"`
+	StoredSize int                      `json:"stored_size"`
+	Size       int                      `json:"size"`
+	Text       string                   `json:"text"`
+	Records    []map[string]interface{} `json:"records,omitempty"`
+}
+
+func formatJSONL(w io.Writer, file string, row *core.Row) error {
+	var text bytes.Buffer
+	if _, err := row.WriteTo(&text); err != nil {
+		return err
+	}
+	recs, err := row.Records()
+	if err != nil {
+		return err
+	}
+	jr := jsonRow{
+		File:       file,
+		Time:       row.Time,
+		Compressed: row.Bin != nil,
+		StoredSize: storedSize(row),
+		Size:       text.Len(),
+		Text:       text.String(),
+		Records:    recs,
+	}
+	if row.Pos != nil {
+		p := row.Pos
+		jr.Pos = jsonPosition{Name: p.Name, CreateAt: p.CreateAt, Offset: p.Offset, HeadHash: p.HeadHash, HashLength: p.HashLength}
+	}
+	if row.Info != nil {
+		jr.RowOffset = &row.Info.Offset
+	}
+	return json.NewEncoder(w).Encode(jr)
+}
+
+func formatDebug(w io.Writer, file string, row *core.Row) error {
+	var text bytes.Buffer
+	if _, err := row.WriteTo(&text); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "--- row file:%s", file)
+	if row.Info != nil {
+		fmt.Fprintf(w, " offset:%d size:%d end:%d checksum1:%08x checksum2:%08x",
+			row.Info.Offset, row.Info.Size, row.Info.Offset+row.Info.Size, row.Info.HeaderSum, row.Info.DataSum)
+	}
+	fmt.Fprintf(w, "\ntime:%s compressed:%v stored:%d size:%d recs:%d\n",
+		row.Time.Format(time.RFC3339Nano), row.Bin != nil, storedSize(row), text.Len(), len(row.Recs))
+	if row.Pos != nil {
+		fmt.Fprintf(w, "pos:%s\n", row.Pos)
+	}
+	_, err := io.WriteString(w, hex.Dump(text.Bytes()))
+	return err
+}
+
+func storedSize(row *core.Row) int {
+	if row.Bin != nil {
+		return len(row.Bin)
+	}
+	return len(row.Text)
+}
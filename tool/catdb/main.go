@@ -4,23 +4,36 @@ import (
 	"flag"
 	"log"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/masahide/ftailer/core"
+	"github.com/masahide/ftailer/tool/internal/timearg"
 )
 
 type Config struct {
-	BufDir string
-	Name   string
-	Period time.Duration // time.Minute
+	BufDir     string
+	Name       string
+	Period     time.Duration // time.Minute
+	Since      string        // 絶対時刻 または 現在からの相対時間 (15m)
+	Until      string
+	FromOffset int64 // このオフセットを超える行から出力
+	Rec        bool  // 書き込み中の .rec ファイルも対象にする
+	Follow     bool  // 書き込み中の .rec ファイルを出力し続ける
+	Format     string
 }
 
 var config = Config{
-	BufDir: "",
-	Name:   "",
-	Period: 1 * time.Minute,
+	BufDir:     "",
+	Name:       "",
+	Period:     1 * time.Minute,
+	FromOffset: -1,
+	Format:     FormatRaw,
 }
 
+var format formatter = formatRaw
+
 var Options = &core.FtailDBOptions{
 	ReadOnly: true,
 	Bin:      true,
@@ -30,45 +43,138 @@ func main() {
 
 	flag.StringVar(&config.Name, "name", config.Name, "logfile")
 	flag.StringVar(&config.BufDir, "bufdir", config.BufDir, "BufDir path")
+	flag.DurationVar(&config.Period, "period", config.Period, "period of DB files")
+	flag.StringVar(&config.Since, "since", config.Since, "show rows at or after the time (e.g. '2015-07-01 12:00', '15m')")
+	flag.StringVar(&config.Until, "until", config.Until, "show rows before the time")
+	flag.Int64Var(&config.FromOffset, "from-offset", config.FromOffset, "show rows after the source file offset")
+	flag.BoolVar(&config.Rec, "rec", config.Rec, "include .rec files being written")
+	flag.BoolVar(&config.Follow, "f", config.Follow, "follow the .rec file being written, like tail -f")
+	flag.StringVar(&config.Format, "format", config.Format, "output format: raw, jsonl, debug")
 	flag.Parse()
 
-	db := &core.DB{Path: config.BufDir, Name: config.Name}
+	now := time.Now()
+	s := &selector{period: config.Period, fromOffset: config.FromOffset}
+	var err error
+	if format, err = newFormatter(config.Format); err != nil {
+		log.Fatalf("-format err:%s", err)
+	}
+	if s.since, err = timearg.Parse(config.Since, now); err != nil {
+		log.Fatalf("-since err:%s", err)
+	}
+	if s.until, err = timearg.Parse(config.Until, now); err != nil {
+		log.Fatalf("-until err:%s", err)
+	}
+
 	// fixed fileを検索
 	if config.BufDir == "" && config.Name == "" && flag.NArg() >= 1 {
-		f := flag.Args()[0]
-		db, err := core.FtailDBOpen(f, 0660, Options, nil)
-		if err != nil {
-			log.Printf("err:%s", err)
-			return
+		catFile(flag.Args()[0], s)
+		return
+	}
+	if config.Follow {
+		if err := follow(&core.DB{Path: config.BufDir, Name: config.Name}, s, os.Stdout); err != nil {
+			log.Fatalf("follow err:%s", err)
 		}
-		log.Printf("open db: %v -------------", f)
-		if _, _, err := db.ReadAll(os.Stdout); err != nil {
-			log.Printf("readDB err:%s", err)
+		return
+	}
+	dbfiles, err := dbFiles(&core.DB{Path: config.BufDir, Name: config.Name}, config.Rec)
+	if err != nil {
+		log.Printf("find err:%s", err)
+		return
+	}
+	if len(dbfiles) == 0 {
+		log.Printf("not such file :%s", config.BufDir)
+		return
+	}
+	for _, f := range dbfiles {
+		span := s.period
+		if strings.HasSuffix(f.Path, core.ArcExt) {
+			span = archivePeriod(f.Path)
 		}
-	} else {
-		dbfiles, err := core.FixGlob(db)
+		if !s.fileSpan(f, span) {
+			continue
+		}
+		catFile(f.Path, s)
+	}
+}
+
+// dbFiles .arc, .fixed (recがtrueの場合は .rec も) を時刻順に返す
+func dbFiles(db *core.DB, rec bool) ([]core.DBFiles, error) {
+	files, err := core.ArcGlob(db)
+	if err != nil {
+		return nil, err
+	}
+	fixed, err := core.FixGlob(db)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, fixed...)
+	if rec {
+		recs, err := core.RecGlob(db)
 		if err != nil {
-			log.Printf("find err:%s", err)
-			return
+			return nil, err
+		}
+		files = append(files, recs...)
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Time.Before(files[j].Time) })
+	return files, nil
+}
+
+func catFile(f string, s *selector) {
+	if strings.HasSuffix(f, core.ArcExt) {
+		catArchive(f, s)
+		return
+	}
+	db, err := core.FtailDBOpen(f, 0660, Options, nil)
+	if err != nil {
+		log.Printf("err:%s", err)
+		return
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("db.Close err:%s", err)
 		}
-		if len(dbfiles) == 0 {
-			log.Printf("not such file :%s", config.BufDir)
-			return
+	}()
+	log.Printf("open db: %v meta:%v -------------", f, db.Meta)
+	err = db.ReadRows(func(row *core.Row) error {
+		if !s.row(row) {
+			return nil
 		}
-		for _, f := range dbfiles {
-			db.Time = f.Time
-			if err = db.Open(core.FixExt, nil); err != nil {
-				log.Printf("not found db: %s", f.Path)
-				return
-			}
-			log.Printf("open db: %v -------------", f)
-			if _, _, err := db.ReadAll(os.Stdout); err != nil {
-				log.Printf("readDB err:%s", err)
-			}
-			if err := db.Close(false); err != nil {
-				log.Printf("db.Close err:%s", err)
-			}
+		return format(os.Stdout, f, row)
+	})
+	if err != nil {
+		log.Printf("readDB err:%s", err)
+	}
+}
 
+// archivePeriod アーカイブのヘッダからアーカイブの期間を読み込む
+func archivePeriod(f string) time.Duration {
+	a, err := core.OpenArchive(f)
+	if err != nil {
+		return 24 * time.Hour
+	}
+	defer a.Close()
+	return a.Period
+}
+
+func catArchive(f string, s *selector) {
+	a, err := core.OpenArchive(f)
+	if err != nil {
+		log.Printf("err:%s", err)
+		return
+	}
+	defer func() {
+		if err := a.Close(); err != nil {
+			log.Printf("archive.Close err:%s", err)
+		}
+	}()
+	log.Printf("open archive: %v meta:%v -------------", f, a.Meta)
+	err = a.ReadRows(func(row *core.Row) error {
+		if !s.row(row) {
+			return nil
 		}
+		return format(os.Stdout, f, row)
+	})
+	if err != nil {
+		log.Printf("readDB err:%s", err)
 	}
 }
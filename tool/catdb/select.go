@@ -0,0 +1,45 @@
+package main
+
+import (
+	"time"
+
+	"github.com/masahide/ftailer/core"
+)
+
+// selector 出力するファイルと行を選択する
+type selector struct {
+	since      time.Time
+	until      time.Time
+	period     time.Duration
+	fromOffset int64 // 負の場合は指定なし
+	started    bool
+}
+
+// fileSpan f.Timeからspanの期間が範囲に含まれるか判定する
+func (s *selector) fileSpan(f core.DBFiles, span time.Duration) bool {
+	if !s.since.IsZero() && !f.Time.Add(span).After(s.since) {
+		return false
+	}
+	if !s.until.IsZero() && !f.Time.Before(s.until) {
+		return false
+	}
+	return true
+}
+
+// row 行のTimeとPositionから出力する行か判定する
+// fromOffsetを超える行が見つかった後は以降の全ての行を出力する
+func (s *selector) row(r *core.Row) bool {
+	if !s.since.IsZero() && r.Time.Before(s.since) {
+		return false
+	}
+	if !s.until.IsZero() && !r.Time.Before(s.until) {
+		return false
+	}
+	if s.fromOffset < 0 || s.started {
+		return true
+	}
+	if r.Pos != nil && r.Pos.Offset > s.fromOffset {
+		s.started = true
+	}
+	return s.started
+}
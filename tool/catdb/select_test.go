@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/masahide/ftailer/core"
+)
+
+func TestSelector(t *testing.T) {
+	base := time.Date(2015, 7, 1, 12, 0, 0, 0, time.Local)
+	s := &selector{since: base.Add(90 * time.Second), until: base.Add(3 * time.Minute), period: time.Minute, fromOffset: 10}
+	for i, want := range []bool{false, true, true, false} {
+		if got := s.fileSpan(core.DBFiles{Time: base.Add(time.Duration(i) * time.Minute)}, s.period); got != want {
+			t.Errorf("fileSpan(%d) => %v, want %v", i, got, want)
+		}
+	}
+	// アーカイブは複数の期間を含む
+	if !s.fileSpan(core.DBFiles{Time: base}, 2*time.Minute) {
+		t.Errorf("fileSpan(0, 2m) => false, want true")
+	}
+	var rowTest = []struct {
+		time   time.Duration
+		offset int64
+		output bool
+	}{
+		{80 * time.Second, 20, false},
+		{100 * time.Second, 5, false},
+		{110 * time.Second, 15, true},
+		{120 * time.Second, 3, true},
+		{180 * time.Second, 30, false},
+	}
+	for _, e := range rowTest {
+		r := &core.Row{Time: base.Add(e.time), Pos: &core.Position{Offset: e.offset}}
+		if got := s.row(r); got != e.output {
+			t.Errorf("row(%s, %d) => %v, want %v", e.time, e.offset, got, e.output)
+		}
+	}
+}
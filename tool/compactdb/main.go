@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/masahide/ftailer/core"
+)
+
+type Config struct {
+	BufDir string
+	Name   string
+	Period time.Duration // アーカイブの期間
+	Delay  time.Duration // 現在時刻からこの時間より前に終わった期間だけまとめる
+}
+
+var config = Config{
+	Period: 1 * time.Hour,
+	Delay:  10 * time.Minute,
+}
+
+func main() {
+	flag.StringVar(&config.Name, "name", config.Name, "logfile")
+	flag.StringVar(&config.BufDir, "bufdir", config.BufDir, "BufDir path")
+	flag.DurationVar(&config.Period, "period", config.Period, "archive period (e.g. 1h, 24h)")
+	flag.DurationVar(&config.Delay, "delay", config.Delay, "compact only periods that ended before now-delay")
+	flag.Parse()
+
+	if config.BufDir == "" || config.Name == "" {
+		flag.Usage()
+		return
+	}
+	db := &core.DB{Path: config.BufDir, Name: config.Name}
+	arcs, err := core.Compact(db, config.Period, time.Now().Add(-config.Delay))
+	for _, a := range arcs {
+		log.Printf("created %s", a)
+	}
+	if err != nil {
+		log.Fatalf("compact err:%s", err)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/masahide/ftailer/core"
+)
+
+// 出力フォーマット
+const (
+	FormatText     = "text"     // 展開したテキストをそのまま
+	FormatNDJSON   = "ndjson"   // 1行1JSONで行のメタデータと一緒に出力
+	FormatColumnar = "columnar" // ヘッダと列毎のJSON配列
+)
+
+// 出力ファイル名の時刻部分 (core と同じ BufDir/Name/日付/時刻 の形式)
+const pathTimeFormat = "20060102/150405"
+
+// exporter 1つの出力ファイルに行を書き込む
+type exporter interface {
+	Write(row *core.Row, text []byte) error
+	Close() error
+}
+
+func formatExt(format string) (string, error) {
+	switch format {
+	case FormatText:
+		return ".txt.gz", nil
+	case FormatNDJSON:
+		return ".ndjson.gz", nil
+	case FormatColumnar:
+		return ".col.gz", nil
+	}
+	return "", fmt.Errorf("unknown format %q", format)
+}
+
+// outputPath DB.Timeを期間の開始時刻に切り捨てた出力ファイルのパス
+func outputPath(dir, name string, t time.Time, ext string) string {
+	return filepath.Join(dir, name, t.Format(pathTimeFormat)+ext)
+}
+
+// newGzip 同じ入力から同じ出力になるようにgzipのヘッダには時刻を入れない
+func newGzip(w io.Writer) *gzip.Writer {
+	zw, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+	return zw
+}
+
+func newExporter(format string, w io.Writer, source string, start time.Time) exporter {
+	zw := newGzip(w)
+	switch format {
+	case FormatNDJSON:
+		return &ndjsonExporter{zw: zw, enc: json.NewEncoder(zw), source: source}
+	case FormatColumnar:
+		return &columnarExporter{zw: zw, source: source, start: start}
+	}
+	return &textExporter{zw: zw}
+}
+
+type textExporter struct {
+	zw *gzip.Writer
+}
+
+func (e *textExporter) Write(row *core.Row, text []byte) error {
+	_, err := e.zw.Write(text)
+	return err
+}
+
+func (e *textExporter) Close() error { return e.zw.Close() }
+
+type ndjsonRow struct {
+	Time     time.Time                `json:"time"`
+	Source   string                   `json:"source"`
+	Path     string                   `json:"path,omitempty"`
+	Offset   int64                    `json:"offset"`
+	HeadHash string                   `json:"head_hash,omitempty"`
+	Text     string                   `json:"text"`
+	Records  []map[string]interface{} `json:"records,omitempty"`
+}
+
+type ndjsonExporter struct {
+	zw     *gzip.Writer
+	enc    *json.Encoder
+	source string
+}
+
+func (e *ndjsonExporter) Write(row *core.Row, text []byte) error {
+	recs, err := row.Records()
+	if err != nil {
+		return err
+	}
+	r := ndjsonRow{Time: row.Time, Source: e.source, Text: string(text), Records: recs}
+	if row.Pos != nil {
+		r.Path, r.Offset, r.HeadHash = row.Pos.Name, row.Pos.Offset, row.Pos.HeadHash
+	}
+	return e.enc.Encode(r)
+}
+
+func (e *ndjsonExporter) Close() error { return e.zw.Close() }
+
+// Column 列形式のファイルの列の定義
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // int64, string, json
+}
+
+// ColumnarHeader 列形式のファイルの1行目
+// 2行目以降はColumnsの順に1列ずつJSON配列で並ぶ
+type ColumnarHeader struct {
+	Source  string    `json:"source"`
+	Start   time.Time `json:"start"`
+	Rows    int       `json:"rows"`
+	Columns []Column  `json:"columns"`
+}
+
+// 固定の列。パース結果はキー毎に "rec." を付けた列にする
+var fixedColumns = []Column{
+	{Name: "time", Type: "int64"}, // UnixNano
+	{Name: "path", Type: "string"},
+	{Name: "offset", Type: "int64"}, // Rowの最終行の行末。他の行はnull
+	{Name: "text", Type: "string"},
+}
+
+const recColumnPrefix = "rec."
+
+type columnarExporter struct {
+	zw     *gzip.Writer
+	source string
+	start  time.Time
+
+	times   []int64
+	paths   []string
+	offsets []*int64
+	texts   []string
+	recs    map[string][]interface{}
+}
+
+func (e *columnarExporter) Write(row *core.Row, text []byte) error {
+	recs, err := row.Records()
+	if err != nil {
+		return err
+	}
+	var path string
+	if row.Pos != nil {
+		path = row.Pos.Name
+	}
+	// 1行ずつ1レコードと組にして出力する
+	// 除外・変換された行があると保存したテキストから元のファイルの位置は分からないため、オフセットはRowの最終行のみ
+	lines := bytes.SplitAfter(text, []byte("\n"))
+	if len(lines) > 1 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		var rec map[string]interface{}
+		if i < len(recs) {
+			rec = recs[i]
+		}
+		var offset *int64
+		if i == len(lines)-1 && row.Pos != nil {
+			offset = &row.Pos.Offset
+		}
+		e.add(row.Time.UnixNano(), path, offset, string(line), rec)
+	}
+	return nil
+}
+
+func (e *columnarExporter) add(t int64, path string, offset *int64, text string, rec map[string]interface{}) {
+	n := len(e.times)
+	for k, v := range rec {
+		if e.recs == nil {
+			e.recs = map[string][]interface{}{}
+		}
+		if _, ok := e.recs[k]; !ok {
+			e.recs[k] = make([]interface{}, n)
+		}
+		e.recs[k] = append(e.recs[k], v)
+	}
+	for k := range e.recs {
+		if _, ok := rec[k]; !ok {
+			e.recs[k] = append(e.recs[k], nil)
+		}
+	}
+	e.times = append(e.times, t)
+	e.paths = append(e.paths, path)
+	e.offsets = append(e.offsets, offset)
+	e.texts = append(e.texts, text)
+}
+
+func (e *columnarExporter) Close() error {
+	keys := make([]string, 0, len(e.recs))
+	for k := range e.recs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := ColumnarHeader{Source: e.source, Start: e.start, Rows: len(e.times)}
+	h.Columns = append(h.Columns, fixedColumns...)
+	for _, k := range keys {
+		h.Columns = append(h.Columns, Column{Name: recColumnPrefix + k, Type: "json"})
+	}
+	values := []interface{}{e.times, e.paths, e.offsets, e.texts}
+	for _, k := range keys {
+		values = append(values, e.recs[k])
+	}
+	enc := json.NewEncoder(e.zw)
+	if err := enc.Encode(h); err != nil {
+		return err
+	}
+	for _, v := range values {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	return e.zw.Close()
+}
+
+// ReadColumnar 列形式のファイルを読み込む
+func ReadColumnar(r io.Reader) (*ColumnarHeader, [][]interface{}, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zr.Close()
+	dec := json.NewDecoder(zr)
+	dec.UseNumber()
+	var h ColumnarHeader
+	if err := dec.Decode(&h); err != nil {
+		return nil, nil, err
+	}
+	cols := make([][]interface{}, len(h.Columns))
+	for i := range cols {
+		if err := dec.Decode(&cols[i]); err != nil {
+			return nil, nil, fmt.Errorf("column %s: %s", h.Columns[i].Name, err)
+		}
+	}
+	return &h, cols, nil
+}
+
+// Export 期間毎に出力ファイルをまとめて書き出す
+type Export struct {
+	DB     *core.DB
+	Out    string
+	Format string
+	Period time.Duration // 出力ファイルの期間
+	Since  time.Time
+	Until  time.Time
+
+	ext   string
+	files map[time.Time]*output
+}
+
+type output struct {
+	path string
+	tmp  *os.File
+	exporter
+}
+
+// Run 対象の期間の .arc, .fixed ファイルを書き出し、作成したファイルのパスを返す
+// 書き込み中の .rec ファイルがある期間は対象外
+func (e *Export) Run() ([]string, error) {
+	var err error
+	if e.ext, err = formatExt(e.Format); err != nil {
+		return nil, err
+	}
+	if e.Period <= 0 || e.Period > 24*time.Hour {
+		return nil, fmt.Errorf("invalid period: %s", e.Period)
+	}
+	files, err := e.inputs()
+	if err != nil {
+		return nil, err
+	}
+	recs, err := core.RecGlob(e.DB)
+	if err != nil {
+		return nil, err
+	}
+	busy := map[time.Time]bool{}
+	for _, f := range recs {
+		busy[core.Truncate(f.Time, e.Period)] = true
+	}
+	e.files = map[time.Time]*output{}
+	for _, f := range files {
+		if err = e.exportFile(f, busy); err != nil {
+			e.abort()
+			return nil, err
+		}
+	}
+	return e.commit()
+}
+
+func (e *Export) inputs() ([]core.DBFiles, error) {
+	files, err := core.ArcGlob(e.DB)
+	if err != nil {
+		return nil, err
+	}
+	fixed, err := core.FixGlob(e.DB)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, fixed...)
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Time.Before(files[j].Time) })
+	return files, nil
+}
+
+func (e *Export) exportFile(f core.DBFiles, busy map[time.Time]bool) error {
+	archive := strings.HasSuffix(f.Path, core.ArcExt)
+	fn := func(row *core.Row) error {
+		t := f.Time
+		if archive && row.Time.After(t) {
+			t = row.Time // アーカイブは出力ファイルの期間より長い場合がある
+		}
+		t = core.Truncate(t, e.Period)
+		if busy[t] || !e.selected(t) {
+			return nil
+		}
+		var text bytes.Buffer
+		if _, err := row.WriteTo(&text); err != nil {
+			return err
+		}
+		if text.Len() == 0 {
+			return nil // Positionのみの行
+		}
+		out, err := e.output(t)
+		if err != nil {
+			return err
+		}
+		return out.Write(row, text.Bytes())
+	}
+	if archive {
+		a, err := core.OpenArchive(f.Path)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		return a.ReadRows(fn)
+	}
+	db, err := core.FtailDBOpen(f.Path, 0644, &core.FtailDBOptions{ReadOnly: true, Bin: true}, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.ReadRows(fn)
+}
+
+func (e *Export) selected(t time.Time) bool {
+	if !e.Since.IsZero() && t.Before(core.Truncate(e.Since, e.Period)) {
+		return false
+	}
+	if !e.Until.IsZero() && !t.Before(e.Until) {
+		return false
+	}
+	return true
+}
+
+func (e *Export) output(t time.Time) (*output, error) {
+	if out, ok := e.files[t]; ok {
+		return out, nil
+	}
+	path := outputPath(e.Out, e.DB.Name, t, e.ext)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	out := &output{path: path, tmp: tmp, exporter: newExporter(e.Format, tmp, e.DB.Name, t)}
+	e.files[t] = out
+	return out, nil
+}
+
+// commit 全ての出力ファイルを閉じて一時ファイルから置き換える
+func (e *Export) commit() ([]string, error) {
+	times := make([]time.Time, 0, len(e.files))
+	for t := range e.files {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	var res []string
+	for _, t := range times {
+		out := e.files[t]
+		err := out.Close()
+		if err == nil {
+			err = out.tmp.Sync()
+		}
+		if cerr := out.tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(out.tmp.Name(), out.path)
+		}
+		if err != nil {
+			os.Remove(out.tmp.Name())
+			return res, err
+		}
+		delete(e.files, t)
+		res = append(res, out.path)
+	}
+	return res, nil
+}
+
+func (e *Export) abort() {
+	for t, out := range e.files {
+		out.tmp.Close()
+		os.Remove(out.tmp.Name())
+		delete(e.files, t)
+	}
+}
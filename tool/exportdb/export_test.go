@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/masahide/ftailer/core"
+)
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exportdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r := &core.DBpool{Path: dir, Name: "name", Period: time.Minute}
+	if _, err := r.Init(); err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2015, 7, 1, 10, 30, 0, 0, time.Local)
+	offset := int64(0)
+	for i := 0; i < 4; i++ {
+		s := fmt.Sprintf("line %d\n", i)
+		var recs []byte
+		if i == 1 { // 複数行のRow
+			s += "line 1b\n"
+			var b bytes.Buffer
+			w := zlib.NewWriter(&b)
+			w.Write([]byte("{\"a\":\"x\"}\nnull\n"))
+			w.Close()
+			recs = b.Bytes()
+		}
+		offset += int64(len(s))
+		row := core.Row{Time: base.Add(time.Duration(i) * 20 * time.Minute), Pos: &core.Position{Name: "test.log", Offset: offset}, Text: s, Recs: recs}
+		if err := r.Put(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.CloseOldDbs(base.Add(3 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	e := &Export{DB: &core.DB{Path: dir, Name: "name"}, Out: out, Format: FormatText, Period: time.Hour}
+	files, err := e.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(out, "name", "20150701", "100000.txt.gz"),
+		filepath.Join(out, "name", "20150701", "110000.txt.gz"),
+	}
+	if fmt.Sprint(files) != fmt.Sprint(want) {
+		t.Fatalf("Run => %v, want %v", files, want)
+	}
+	if s := gunzip(t, files[1]); s != "line 2\nline 3\n" {
+		t.Errorf("%s => %q", files[1], s)
+	}
+	first, _ := ioutil.ReadFile(files[0])
+	if _, err := e.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if second, _ := ioutil.ReadFile(files[0]); !bytes.Equal(first, second) {
+		t.Errorf("export is not deterministic")
+	}
+
+	e.Format = FormatColumnar
+	files, err = e.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	h, cols, err := ReadColumnar(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Rows != 3 || len(cols) != len(fixedColumns)+1 ||
+		fmt.Sprint(cols[2]) != "[7 <nil> 22]" ||
+		fmt.Sprint(cols[3]) != fmt.Sprint([]string{"line 0\n", "line 1\n", "line 1b\n"}) ||
+		fmt.Sprint(cols[4]) != "[<nil> x <nil>]" {
+		t.Errorf("ReadColumnar => %+v %v", h, cols)
+	}
+}
+
+func gunzip(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/masahide/ftailer/core"
+	"github.com/masahide/ftailer/tool/internal/timearg"
+)
+
+type Config struct {
+	BufDir string
+	Name   string
+	Out    string
+	Format string
+	Period time.Duration // 出力ファイルの期間
+	Since  string
+	Until  string
+}
+
+var config = Config{
+	Format: FormatText,
+	Period: 1 * time.Hour,
+}
+
+func main() {
+	flag.StringVar(&config.Name, "name", config.Name, "logfile")
+	flag.StringVar(&config.BufDir, "bufdir", config.BufDir, "BufDir path")
+	flag.StringVar(&config.Out, "out", config.Out, "output directory")
+	flag.StringVar(&config.Format, "format", config.Format, "output format: text, ndjson, columnar")
+	flag.DurationVar(&config.Period, "period", config.Period, "period of output files (e.g. 1h, 24h)")
+	flag.StringVar(&config.Since, "since", config.Since, "export periods at or after the time (e.g. '2015-07-01 12:00')")
+	flag.StringVar(&config.Until, "until", config.Until, "export periods before the time")
+	flag.Parse()
+
+	if config.BufDir == "" || config.Name == "" || config.Out == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	e := &Export{
+		DB:     &core.DB{Path: config.BufDir, Name: config.Name},
+		Out:    config.Out,
+		Format: config.Format,
+		Period: config.Period,
+	}
+	var err error
+	if e.Since, err = timearg.Parse(config.Since, time.Now()); err != nil {
+		log.Fatalf("-since err:%s", err)
+	}
+	if e.Until, err = timearg.Parse(config.Until, time.Now()); err != nil {
+		log.Fatalf("-until err:%s", err)
+	}
+	files, err := e.Run()
+	for _, f := range files {
+		log.Printf("exported %s", f)
+	}
+	if err != nil {
+		log.Fatalf("export err:%s", err)
+	}
+}
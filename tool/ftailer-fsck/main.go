@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/masahide/ftailer/core"
+)
+
+type Config struct {
+	BufDir string
+	Name   string
+	Repair string // truncate or salvage
+	Quiet  bool
+}
+
+var config = Config{}
+
+const (
+	repairTruncate = "truncate" // 最後の正常な行の後ろを切り詰める
+	repairSalvage  = "salvage"  // 正常な行だけを新しいファイルに書き出す
+)
+
+func main() {
+	flag.StringVar(&config.Name, "name", config.Name, "logfile")
+	flag.StringVar(&config.BufDir, "bufdir", config.BufDir, "BufDir path")
+	flag.StringVar(&config.Repair, "repair", config.Repair, "repair broken files: truncate or salvage (stop ftailer before repairing)")
+	flag.BoolVar(&config.Quiet, "q", config.Quiet, "report only files with errors")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [options] [-bufdir dir -name name | file...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	switch config.Repair {
+	case "", repairTruncate, repairSalvage:
+	default:
+		log.Fatalf("unknown -repair %q", config.Repair)
+	}
+	var files []core.DBFiles
+	if config.BufDir == "" && config.Name == "" {
+		for _, f := range flag.Args() {
+			files = append(files, core.DBFiles{Path: f})
+		}
+	} else {
+		var err error
+		if files, err = dbFiles(&core.DB{Path: config.BufDir, Name: config.Name}); err != nil {
+			log.Fatalf("find err:%s", err)
+		}
+	}
+	if len(files) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if !check(files) {
+		os.Exit(1)
+	}
+}
+
+// dbFiles .fixed, .rec, broken ファイルを時刻順に返す
+func dbFiles(db *core.DB) ([]core.DBFiles, error) {
+	var files []core.DBFiles
+	for _, glob := range []func(*core.DB) ([]core.DBFiles, error){core.FixGlob, core.RecGlob, core.BrokenGlob} {
+		f, err := glob(db)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f...)
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Time.Before(files[j].Time) })
+	return files, nil
+}
+
+// check 全ファイルを検証する。エラーがなければtrue
+func check(files []core.DBFiles) bool {
+	ok := true
+	var prev *core.VerifyResult
+	for _, f := range files {
+		res, err := core.Verify(f.Path)
+		if err != nil {
+			log.Printf("%s: %s", f.Path, err)
+			ok = false
+			continue
+		}
+		if w := core.CheckContinuity(prev, res); w != "" {
+			fmt.Printf("WARN %s\n", w)
+		}
+		prev = res
+		report(res)
+		if res.OK() {
+			continue
+		}
+		ok = false
+		if err := repair(res); err != nil {
+			log.Printf("%s: repair err:%s", f.Path, err)
+		}
+	}
+	return ok
+}
+
+func report(res *core.VerifyResult) {
+	if config.Quiet && res.OK() {
+		return
+	}
+	status := "OK"
+	if !res.OK() {
+		status = "BROKEN"
+	}
+	fmt.Printf("%-6s %s rows:%d size:%d good:%d", status, res.Path, res.Rows, res.FileSize, res.GoodSize)
+	if res.Salvaged > 0 {
+		fmt.Printf(" salvageable:%d", res.Salvaged)
+	}
+	if res.Last != nil {
+		fmt.Printf(" time:%s-%s offset:%d", res.FirstTime.Format("15:04:05"), res.LastTime.Format("15:04:05"), res.Last.Offset)
+	}
+	fmt.Println()
+	for _, e := range res.Errors {
+		fmt.Printf("  ERROR %s\n", e)
+	}
+	for _, w := range res.Warnings {
+		fmt.Printf("  WARN  %s\n", w)
+	}
+}
+
+func repair(res *core.VerifyResult) error {
+	switch config.Repair {
+	case repairTruncate:
+		if err := core.RepairTruncate(res); err != nil {
+			return err
+		}
+		fmt.Printf("  truncated %s to %d bytes\n", res.Path, res.GoodSize)
+	case repairSalvage:
+		n, err := core.RepairSalvage(res.Path)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  salvaged %d rows into %s\n", n, res.Path)
+	}
+	return nil
+}
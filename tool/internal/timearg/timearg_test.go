@@ -0,0 +1,34 @@
+package timearg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2015, 7, 1, 12, 30, 0, 0, time.Local)
+	var parseTest = []struct {
+		input  string
+		output time.Time
+	}{
+		{"", time.Time{}},
+		{"15m", now.Add(-15 * time.Minute)},
+		{"-2h", now.Add(-2 * time.Hour)},
+		{"2015-06-30 10:00", time.Date(2015, 6, 30, 10, 0, 0, 0, time.Local)},
+		{"2015-06-30T10:00:00Z", time.Date(2015, 6, 30, 10, 0, 0, 0, time.UTC)},
+		{"09:15", time.Date(2015, 7, 1, 9, 15, 0, 0, time.Local)},
+	}
+	for _, e := range parseTest {
+		output, err := Parse(e.input, now)
+		if err != nil {
+			t.Errorf("Parse(%q) err:%s", e.input, err)
+			continue
+		}
+		if !output.Equal(e.output) {
+			t.Errorf("Parse(%q) => %s, want %s", e.input, output, e.output)
+		}
+	}
+	if _, err := Parse("yesterday", now); err == nil {
+		t.Errorf("Parse(yesterday) err is nil")
+	}
+}
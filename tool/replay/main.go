@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/masahide/ftailer/in/ftail"
+	"github.com/masahide/ftailer/parser"
+)
+
+type Config struct {
+	BufDir      string
+	Name        string
+	Period      time.Duration
+	MaxBufSize  int
+	TimeLayout  string // 指定しない場合はファイルの更新時刻を行の時刻にする
+	TimePattern string
+	TimeJSON    string
+}
+
+var config = Config{
+	Period:     1 * time.Minute,
+	MaxBufSize: 64 * 1024,
+}
+
+func main() {
+	flag.StringVar(&config.Name, "name", config.Name, "logfile")
+	flag.StringVar(&config.BufDir, "bufdir", config.BufDir, "BufDir path")
+	flag.DurationVar(&config.Period, "period", config.Period, "period of DB files (same as the running ftailer)")
+	flag.IntVar(&config.MaxBufSize, "maxbuf", config.MaxBufSize, "flush rows larger than the size")
+	flag.StringVar(&config.TimeLayout, "time-layout", config.TimeLayout, "layout of the event time in lines (time.Parse layout, unix, unixmilli). file mtime is used if empty")
+	flag.StringVar(&config.TimePattern, "time-pattern", config.TimePattern, "regexp to extract the event time")
+	flag.StringVar(&config.TimeJSON, "time-json", config.TimeJSON, "JSON field of the event time")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s -bufdir dir -name name [options] file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if config.BufDir == "" || config.Name == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	c := ftail.Config{
+		Name:       config.Name,
+		BufDir:     config.BufDir,
+		Period:     config.Period,
+		MaxBufSize: config.MaxBufSize,
+	}
+	if config.TimeLayout != "" {
+		c.EventTime = &parser.TimeExtractor{
+			Layout:    config.TimeLayout,
+			Pattern:   config.TimePattern,
+			JSONField: config.TimeJSON,
+		}
+	}
+	for _, f := range flag.Args() {
+		res, err := ftail.Replay(context.Background(), c, f)
+		if err != nil {
+			log.Fatalf("replay %s err:%s", f, err)
+		}
+		log.Printf("replay %s: lines:%d rows:%d skipped:%d", f, res.Lines, res.Rows, res.Skipped)
+	}
+}
@@ -2,78 +2,99 @@ package watch
 
 import (
 	"context"
-	"log"
+	"os"
+	"time"
 )
 
-type FileChanges struct {
-	Modified chan int // Channel to get notified of modifications
-	/*
-		Truncated chan bool // Channel to get notified of truncations
-		Deleted   chan bool // Channel to get notified of deletions/renames
-	*/
-}
+// EventKind is the kind of a file change.
+type EventKind int
 
 const (
-	chanSize = 1000
-
-	None = iota
-	Rotated
-	Modified
+	None              EventKind = iota
+	Modified                    // appended or rewritten
+	Truncated                   // the size became smaller
+	RotatedByRename             // renamed away and a new file was created at the path
+	RotatedByCreate             // a new file appeared at the path (the rename or deletion was not seen)
+	Deleted                     // deleted and not recreated
+	Moved                       // renamed away and not recreated
+	Unmounted                   // the filesystem of the file was unmounted
+	PermissionChanged           // the mode of the file changed
+	Retargeted                  // the symlink now points to another file
 )
 
-func NewFileChanges() *FileChanges {
-	return &FileChanges{
-		Modified: make(chan int, chanSize),
-	}
+var kindNames = map[EventKind]string{
+	None:              "none",
+	Modified:          "modified",
+	Truncated:         "truncated",
+	RotatedByRename:   "rotated-by-rename",
+	RotatedByCreate:   "rotated-by-create",
+	Deleted:           "deleted",
+	Moved:             "moved",
+	Unmounted:         "unmounted",
+	PermissionChanged: "permission-changed",
+	Retargeted:        "retargeted",
 }
 
-func (fc *FileChanges) NotifyRotated(ctx context.Context) {
-	select {
-	case <-ctx.Done():
-	case fc.Modified <- Rotated:
+func (k EventKind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
 	}
+	return "unknown"
+}
 
+// Rotated reports whether a new file is at the path.
+func (k EventKind) Rotated() bool {
+	return k == RotatedByRename || k == RotatedByCreate || k == Retargeted
 }
-func (fc *FileChanges) NotifyModified(ctx context.Context) {
-	select {
-	case <-ctx.Done():
-	case fc.Modified <- Modified:
-	}
+
+// Removed reports whether the file is no longer at the path.
+func (k EventKind) Removed() bool {
+	return k == Deleted || k == Moved || k == Unmounted
 }
 
-/*
-func (fc *FileChanges) NotifyTruncated() {
-	sendOnlyIfEmpty(fc.Truncated)
+// Event is a change of the watched file.
+// Sizes and inodes are zero when unknown (e.g. the file no longer exists).
+type Event struct {
+	Kind     EventKind
+	OldSize  int64
+	NewSize  int64
+	OldInode uint64
+	NewInode uint64
+	Time     time.Time
 }
 
-func (fc *FileChanges) NotifyDeleted() {
-	sendOnlyIfEmpty(fc.Deleted)
+// newEvent makes an Event from the stats before and after the change. Either may be nil.
+func newEvent(kind EventKind, old, new os.FileInfo) Event {
+	ev := Event{Kind: kind, Time: time.Now()}
+	if old != nil {
+		ev.OldSize, ev.OldInode = old.Size(), inode(old)
+	}
+	if new != nil {
+		ev.NewSize, ev.NewInode = new.Size(), inode(new)
+	}
+	return ev
 }
 
-func (fc *FileChanges) NotifyClosed() {
-	sendOnlyIfEmpty(fc.Rotated)
+type FileChanges struct {
+	Events chan Event // Channel to get notified of changes
 }
-*/
 
-func (fc *FileChanges) Close() {
-	log.Printf("Close FileChanges.")
-	close(fc.Modified)
-	/*
-		close(fc.Truncated)
-		close(fc.Deleted)
-	*/
+const chanSize = 1000
+
+func NewFileChanges() *FileChanges {
+	return &FileChanges{
+		Events: make(chan Event, chanSize),
+	}
 }
 
-/*
-// sendOnlyIfEmpty sends on a bool channel only if the channel has no
-// backlog to be read by other goroutines. This concurrency pattern
-// can be used to notify other goroutines if and only if they are
-// looking for it (i.e., subsequent notifications can be compressed
-// into one).
-func sendOnlyIfEmpty(ch chan bool) {
+// Notify sends ev unless ctx is done.
+func (fc *FileChanges) Notify(ctx context.Context, ev Event) {
 	select {
-	case ch <- true:
-	default:
+	case <-ctx.Done():
+	case fc.Events <- ev:
 	}
 }
-*/
+
+func (fc *FileChanges) Close() {
+	close(fc.Events)
+}
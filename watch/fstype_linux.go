@@ -0,0 +1,48 @@
+//go:build linux
+
+package watch
+
+import (
+	"os"
+	"syscall"
+)
+
+// f_type of statfs(2) where inotify does not receive changes made by other hosts.
+// overlayfs is not included: changes through the mount are notified and the stat of InotifyFileWatcher catches the rest.
+var unreliableFSTypes = map[uint32]string{
+	0x6969:     "nfs",
+	0x517b:     "smb",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x65735546: "fuse",
+	0x01021997: "9p",
+	0x00c36400: "ceph",
+	0x5346414f: "afs",
+}
+
+// unreliableFS pathのファイルシステムでinotifyが変更を取りこぼす場合はその名前を返す
+func unreliableFS(path string) (string, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return "", false
+	}
+	name, ok := unreliableFSTypes[uint32(st.Type)]
+	return name, ok
+}
+
+// deviceID fiのファイルシステムのデバイス番号
+func deviceID(fi os.FileInfo) (uint64, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
+
+// inode fiのinode番号
+func inode(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
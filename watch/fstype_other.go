@@ -0,0 +1,14 @@
+//go:build !linux
+
+package watch
+
+import "os"
+
+// unreliableFS linux以外では判定しない
+func unreliableFS(path string) (string, bool) { return "", false }
+
+// deviceID linux以外では判定しない
+func deviceID(fi os.FileInfo) (uint64, bool) { return 0, false }
+
+// inode linux以外では0
+func inode(fi os.FileInfo) uint64 { return 0 }
@@ -1,9 +1,11 @@
 package watch
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"time"
@@ -12,27 +14,113 @@ import (
 )
 
 // InotifyFileWatcher uses inotify to monitor file changes.
+// The inotify instances are shared with the other watchers through InotifyManager.
 type InotifyFileWatcher struct {
 	Filename string
-	dw       *fsnotify.Watcher
-	w        *fsnotify.Watcher
-	delay    time.Duration
+	Logger   *slog.Logger
+	// StatInterval is the interval to stat the file in addition to inotify events,
+	// to catch changes inotify missed. 0 means DefaultStatInterval, negative disables it.
+	StatInterval time.Duration
+	// Symlink follows the target of Filename, which is a symlink retargeted at rotation.
+	Symlink bool
+
+	m      *InotifyManager
+	dir    *Subscription // events of the parent directory
+	delay  time.Duration
+	misses int     // statで検出した変更の数
+	poll   Options // ファイルを監視できない場合のPollingFileWatcherの設定
 }
 
-func NewInotifyFileWatcher(filename string, dw, w *fsnotify.Watcher, delay time.Duration) *InotifyFileWatcher {
+// NewInotifyFileWatcher watches the parent directory of filename for new files.
+func NewInotifyFileWatcher(filename string, m *InotifyManager, delay time.Duration) (*InotifyFileWatcher, error) {
+	dir, err := m.WatchDir(filepath.Dir(filename))
+	if err != nil {
+		return nil, err
+	}
 	fw := &InotifyFileWatcher{
 		Filename: filename,
-		dw:       dw,
-		w:        w,
+		m:        m,
+		dir:      dir,
 		delay:    delay,
 	}
-	return fw
+	return fw, nil
+}
+
+// DefaultStatInterval is the default of InotifyFileWatcher.StatInterval.
+const DefaultStatInterval = time.Second
+
+// Options are the options of NewFileWatcher.
+type Options struct {
+	Poll         bool          // use PollingFileWatcher
+	ReOpenDelay  time.Duration // rotate after this delay from the creation of a new file
+	StatInterval time.Duration // InotifyFileWatcher.StatInterval
+	Symlink      bool          // filename is a symlink retargeted at rotation
+
+	PollInterval    time.Duration // PollingFileWatcher.Interval
+	MaxPollInterval time.Duration // PollingFileWatcher.MaxInterval
+
+	Logger *slog.Logger
+}
+
+// NewFileWatcher returns an InotifyFileWatcher, or a PollingFileWatcher if o.Poll is true,
+// the file is on a filesystem where inotify is unreliable (NFS, CIFS, FUSE...)
+// or the inotify instance/watch limit has been reached.
+func NewFileWatcher(filename string, o Options) (FileWatcher, error) {
+	if !o.Poll {
+		iw, err := newInotifyFileWatcher(filename, o)
+		if err == nil {
+			return iw, nil
+		}
+		if err != errUnreliableFS && !IsWatchLimit(err) {
+			return nil, err
+		}
+		cmp.Or(o.Logger, slog.Default()).Warn("fall back to polling", "file", filename, "err", err)
+	}
+	return newPollingFileWatcher(filename, o), nil
+}
+
+func newPollingFileWatcher(filename string, o Options) *PollingFileWatcher {
+	pw := NewPollingFileWatcher(filename)
+	pw.Logger = o.Logger
+	pw.Interval = o.PollInterval
+	pw.MaxInterval = o.MaxPollInterval
+	pw.Symlink = o.Symlink
+	pw.Delay = o.ReOpenDelay
+	return pw
+}
+
+var errUnreliableFS = errors.New("inotify is unreliable on this filesystem")
+
+func newInotifyFileWatcher(filename string, o Options) (*InotifyFileWatcher, error) {
+	dir := filepath.Dir(filename)
+	if fs, ok := unreliableFS(dir); ok {
+		cmp.Or(o.Logger, slog.Default()).Info("unreliable filesystem for inotify", "file", filename, "fs", fs)
+		return nil, errUnreliableFS
+	}
+	m, err := DefaultInotifyManager()
+	if err != nil {
+		return nil, err
+	}
+	iw, err := NewInotifyFileWatcher(filename, m, o.ReOpenDelay)
+	if err != nil {
+		return nil, err
+	}
+	iw.Logger = o.Logger
+	iw.StatInterval = o.StatInterval
+	iw.Symlink = o.Symlink
+	iw.poll = o
+	return iw, nil
+}
+
+// Close removes the watch of the parent directory.
+func (fw *InotifyFileWatcher) Close() error {
+	return fw.dir.Close()
 }
 
 func (fw *InotifyFileWatcher) BlockUntilExists(ctx context.Context) error {
 	var err error
 	// Do a real check now as the file might have been created before
-	// calling `WatchFlags` above.
+	// the parent directory was watched.
 	if _, err = os.Stat(fw.Filename); !os.IsNotExist(err) {
 		// file exists, or stat returned an error.
 		return err
@@ -42,12 +130,20 @@ func (fw *InotifyFileWatcher) BlockUntilExists(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	// 取りこぼした作成や別のディレクトリでのリンク先の作成はstatで検出する
+	var statC <-chan time.Time
+	if interval := fw.statInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		statC = ticker.C
+	}
 	for {
 		select {
-		case evt, ok := <-fw.dw.Event:
-			if !ok {
-				return fmt.Errorf("fsnotify watcher has been closed")
+		case <-statC:
+			if _, err := os.Stat(fw.Filename); !os.IsNotExist(err) {
+				return err
 			}
+		case evt := <-fw.dir.Event:
 			evtName, err := filepath.Abs(evt.Name)
 			if err != nil {
 				return err
@@ -55,6 +151,8 @@ func (fw *InotifyFileWatcher) BlockUntilExists(ctx context.Context) error {
 			if evtName == fwFilename {
 				return nil
 			}
+		case err := <-fw.dir.Error:
+			return fmt.Errorf("fsnotify watcher error: %w", err)
 		case <-ctx.Done():
 			return ctx.Err()
 		}
@@ -62,97 +160,331 @@ func (fw *InotifyFileWatcher) BlockUntilExists(ctx context.Context) error {
 }
 
 func (fw *InotifyFileWatcher) ChangeEvents(ctx context.Context, fi os.FileInfo) *FileChanges {
+	target := fw.target()
+	file, err := fw.m.WatchFile(target)
+	if err != nil {
+		fw.logger().Warn("error watching file. fall back to polling", "err", err)
+		fw.poll.Logger = fw.Logger
+		return newPollingFileWatcher(fw.Filename, fw.poll).ChangeEvents(ctx, fi)
+	}
+	// リンク先のディレクトリも監視し、リンク先の削除・移動のイベントを受け取る
+	var tdir *Subscription
+	if target != fw.Filename && filepath.Dir(target) != filepath.Dir(fw.Filename) {
+		if tdir, err = fw.m.WatchDir(filepath.Dir(target)); err != nil {
+			fw.logger().Warn("error watching the directory of the symlink target", "target", target, "err", err)
+			tdir = nil
+		}
+	}
 	changes := NewFileChanges()
+	go fw.changeEventsWorker(ctx, fi, target, file, tdir, changes)
+	return changes
+}
 
-	err := fw.w.Watch(fw.Filename)
+// target Symlinkの場合はリンク先のパス
+func (fw *InotifyFileWatcher) target() string {
+	if !fw.Symlink {
+		return fw.Filename
+	}
+	target, err := filepath.EvalSymlinks(fw.Filename)
 	if err != nil {
-		log.Fatalf("Error watching %v: %v", fw.Filename, err)
+		return fw.Filename
 	}
-	go fw.changeEventsWorker(ctx, changes)
-	return changes
+	return target
+}
+
+func (fw *InotifyFileWatcher) logger() *slog.Logger {
+	return cmp.Or(fw.Logger, slog.Default()).With("file", fw.Filename)
 }
 
-func (fw *InotifyFileWatcher) removeWatch(ctx context.Context) {
+func (fw *InotifyFileWatcher) removeWatch(file *Subscription) {
 	for i := 0; i < 30; i++ {
-		err := fw.w.RemoveWatch(fw.Filename)
+		err := file.Close()
 		if err == nil {
 			return
 		}
 		switch err := err.(type) {
 		default:
-			log.Printf("RemoveWatch(%s) err:%s", fw.Filename, err)
+			fw.logger().Warn("RemoveWatch failed", "err", err)
 			return
 		case *os.SyscallError:
-			log.Printf("RemoveWatch(%s) SysCallError:%s", fw.Filename, err)
+			fw.logger().Warn("RemoveWatch failed. retrying", "err", err)
 		}
 		time.Sleep(5 * time.Second)
 	}
 }
 
-func (fw *InotifyFileWatcher) changeEventsWorker(ctx context.Context, changes *FileChanges) {
-	defer fw.removeWatch(ctx)
+// changeEventsWorker
+// ディレクトリ監視のIsCreateの後、ファイル監視で古いファイルのIsCloseWriteを受け取ったらすぐにrotateし、受け取らなければdelay経過でrotateする
+// 削除・移動された場合もdelayの間は古いファイルを読み続け、再作成されなければDeleted, Movedを通知する
+// StatIntervalごとにファイルをstatし、inotifyが取りこぼした変更・作成・削除も検出する
+// Symlinkの場合はリンクの付け替えもdelay経過でRetargetedを通知する
+func (fw *InotifyFileWatcher) changeEventsWorker(ctx context.Context, fi os.FileInfo, target string, file, tdir *Subscription, changes *FileChanges) {
+	// changesを閉じると開き直したtailが同じパスをWatchFileするため、先にwatchを外す
 	defer changes.Close()
-	var inCreate bool
+	defer fw.logger().Debug("close FileChanges")
+	defer fw.removeWatch(file)
+	var tdirEvent <-chan *fsnotify.FileEvent
+	if tdir != nil {
+		defer tdir.Close()
+		tdirEvent = tdir.Event
+	}
 	var CreateTimer <-chan time.Time
+	inCreate := false   // 同じ名前のファイルが作成された
+	removed := None     // 削除・移動された (Deleted, Moved)
+	retargeted := false // リンクが付け替えられた
 	fwFilename, err := filepath.Abs(fw.Filename)
 	if err != nil {
 		return
 	}
+	st := newStatChecker(target, fi)
+	var statC <-chan time.Time
+	if interval := fw.statInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		statC = ticker.C
+	}
+	wait := func() {
+		if CreateTimer == nil {
+			CreateTimer = time.After(fw.delay)
+		}
+	}
+	remove := func(kind EventKind) {
+		if removed == None {
+			fw.logger().Debug("file removed. waiting for recreation", "event", kind.String(), "delay", fw.delay)
+			removed = kind
+		}
+		wait()
+	}
+	retarget := func() {
+		if !fw.Symlink || retargeted {
+			return
+		}
+		if t := fw.target(); t != target {
+			fw.logger().Debug("symlink retargeted", "old", target, "new", t, "delay", fw.delay)
+			retargeted = true
+			wait()
+		}
+	}
+	// rotate 再作成されていればrotate、されていなければDeleted, Movedを通知する
+	rotate := func() {
+		cur, err := os.Stat(fw.Filename)
+		if err != nil {
+			cur = nil
+		}
+		recreated := cur != nil && (st.orig == nil || !os.SameFile(st.orig, cur))
+		if removed != None && !recreated {
+			fw.logger().Info("file removed", "event", removed.String())
+			changes.Notify(ctx, newEvent(removed, st.fi, nil))
+			return
+		}
+		kind := RotatedByCreate
+		if removed == Moved {
+			kind = RotatedByRename
+		}
+		fw.logger().Debug("rotate", "event", kind.String())
+		changes.Notify(ctx, newEvent(kind, st.fi, cur))
+	}
+	// handle statChecker.checkの結果の処理。trueの場合は終了する
+	handle := func(ev Event, missed bool) bool {
+		switch ev.Kind {
+		case Modified:
+			if missed {
+				fw.missed(ev.Kind)
+			}
+			changes.Notify(ctx, ev)
+		case PermissionChanged:
+			changes.Notify(ctx, ev)
+		case Truncated:
+			fw.logger().Info("file truncated", "old_size", ev.OldSize, "new_size", ev.NewSize)
+			changes.Notify(ctx, ev)
+			return true
+		case RotatedByCreate:
+			if missed && CreateTimer == nil {
+				fw.missed(ev.Kind)
+			}
+			wait()
+		case Deleted:
+			if missed && removed == None {
+				fw.missed(ev.Kind)
+			}
+			remove(Deleted)
+		case Unmounted:
+			fw.logger().Info("filesystem unmounted")
+			changes.Notify(ctx, ev)
+			return true
+		}
+		return false
+	}
 
 	for {
-		var evt *fsnotify.FileEvent
-		var ok bool
-
 		select {
 		case <-ctx.Done():
 			return
-		case evt, ok = <-fw.dw.Event: // ディレクトリ監視イベント
-			if !ok {
+		case evt := <-fw.dir.Event: // ディレクトリ監視イベント
+			evtName, err := filepath.Abs(evt.Name)
+			if err != nil {
 				return
 			}
-			if !evt.IsCreate() { // IsCreate 以外は無視
+			if evtName != fwFilename {
 				continue
 			}
-			evtName, err := filepath.Abs(evt.Name)
-			if err != nil {
-				return
+			if fw.Symlink { // リンクの作成・削除・移動
+				retarget()
+				continue
 			}
-			if evtName == fwFilename {
+			switch {
+			case evt.IsCreate():
+				if !inCreate {
+					fw.logger().Debug("received IsCreate")
+				}
 				inCreate = true
-				log.Printf("Received IsCreate: %s", fwFilename)
-				CreateTimer = time.After(fw.delay)
+				wait()
+			case evt.IsDelete():
+				remove(Deleted)
+			case evt.IsRename():
+				remove(Moved)
+			}
+		case evt := <-tdirEvent: // リンク先の削除・移動
+			if evtName, err := filepath.Abs(evt.Name); err != nil || evtName != target {
 				continue
 			}
-		case err, ok := <-fw.dw.Error: // ディレクトリ監視イベント
-			if !ok {
-				return
+			switch {
+			case evt.IsDelete():
+				remove(Deleted)
+			case evt.IsRename():
+				remove(Moved)
 			}
-			log.Printf("fw.Filename=%s, fw.dw.Error:%s", fw.Filename, err)
-		case evt, ok = <-fw.w.Event: // ファイル監視イベント
-			if !ok {
-				return
+		case err := <-fw.dir.Error:
+			fw.logger().Warn("directory watcher error", "err", err)
+		case evt := <-file.Event: // ファイル監視イベント
+			switch {
+			case evt.IsCloseWrite():
+				if inCreate && !retargeted {
+					fw.logger().Info("received IsCreate and IsCloseWrite. rotate")
+					rotate()
+					return
+				}
+			case evt.IsModify():
+				ev := st.check()
+				switch ev.Kind {
+				case Modified, Truncated, PermissionChanged, Unmounted:
+					if handle(ev, false) {
+						return
+					}
+				default: // statでは変化が見えない、または置き換わる前の古いファイルへの書き込み
+					handle(ev, false)
+					changes.Notify(ctx, newEvent(Modified, st.fi, st.fi))
+				}
+			case evt.IsDelete():
+				remove(Deleted)
+			case evt.IsRename():
+				remove(Moved)
+			case evt.IsAttrib():
+				// 開いているファイルはunlinkしてもIN_DELETE_SELFが届かないため、nlinkの変化(IN_ATTRIB)でも確認する
+				if handle(st.check(), false) {
+					return
+				}
 			}
-		case err, ok := <-fw.w.Error: // ファイル監視イベント
-			if !ok {
+		case err := <-file.Error:
+			fw.logger().Warn("file watcher error", "err", err)
+		case <-statC:
+			retarget()
+			if handle(st.check(), true) {
 				return
 			}
-			log.Printf("fw.Filename=%s, fw.w.Error:%s", fw.Filename, err)
 		case <-CreateTimer:
-			log.Printf("IsCreate timeout: %s", fwFilename)
-			changes.NotifyRotated(ctx) //IsCreateからタイムアウトしたら強制rotate
+			if retargeted {
+				cur, _ := os.Stat(fw.Filename)
+				fw.logger().Info("symlink retargeted. rotate", "old", target, "new", fw.target())
+				changes.Notify(ctx, newEvent(Retargeted, st.fi, cur))
+				return
+			}
+			fw.logger().Info("IsCreate timeout. rotate", "delay", fw.delay)
+			rotate() //IsCreateからタイムアウトしたら強制rotate
 			return
 		}
+	}
+}
 
-		switch {
-		case evt.IsCloseWrite():
-			if inCreate {
-				log.Printf("Received IsCreate & IsCloseWrite: %s", fwFilename)
-				changes.NotifyRotated(ctx)
-				return
-			}
-		case evt.IsModify():
-			changes.NotifyModified(ctx)
+func (fw *InotifyFileWatcher) statInterval() time.Duration {
+	if fw.StatInterval == 0 {
+		return DefaultStatInterval
+	}
+	return fw.StatInterval
+}
+
+// missLimit この回数inotifyが変更を取りこぼしたら警告する
+const missLimit = 3
+
+// missed inotifyが取りこぼした変更をstatで検出した
+func (fw *InotifyFileWatcher) missed(kind EventKind) {
+	fw.misses++
+	if fw.misses == missLimit {
+		fw.logger().Warn("inotify seems unreliable for this file. changes are detected by stat", "misses", fw.misses, "interval", fw.statInterval())
+		return
+	}
+	fw.logger().Debug("inotify missed a change. detected by stat", "event", kind.String())
+}
+
+// statChecker 前回のstatと比較して変更を判定する
+type statChecker struct {
+	filename string
+	orig     os.FileInfo // ChangeEventsの時点のファイル
+	fi       os.FileInfo // 前回のstat (置き換わる前のファイル)
+	dirDev   uint64
+	hasDev   bool
+}
+
+func newStatChecker(filename string, fi os.FileInfo) *statChecker {
+	s := &statChecker{filename: filename, orig: fi, fi: fi}
+	if dfi, err := os.Stat(filepath.Dir(filename)); err == nil {
+		s.dirDev, s.hasDev = deviceID(dfi)
+	}
+	return s
+}
+
+// check ファイルをstatし、前回からの変更を返す
+// 別のファイルに置き換わった場合はRotatedByCreate、なくなった場合はDeletedかUnmounted
+func (s *statChecker) check() Event {
+	fi, err := os.Stat(s.filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return Event{Kind: None}
+		}
+		if s.unmounted() {
+			return newEvent(Unmounted, s.fi, nil)
 		}
+		return newEvent(Deleted, s.fi, nil)
+	}
+	if s.fi == nil {
+		s.fi = fi
+		return Event{Kind: None}
 	}
+	if !os.SameFile(s.fi, fi) {
+		return newEvent(RotatedByCreate, s.fi, fi)
+	}
+	prev := s.fi
+	s.fi = fi
+	switch {
+	case fi.Size() < prev.Size():
+		return newEvent(Truncated, prev, fi)
+	case fi.Mode() != prev.Mode():
+		return newEvent(PermissionChanged, prev, fi)
+	case fi.Size() != prev.Size() || !fi.ModTime().Equal(prev.ModTime()):
+		return newEvent(Modified, prev, fi)
+	}
+	return Event{Kind: None}
+}
 
+// unmounted ディレクトリのデバイスが変わった (マウントポイントの下が見えている)
+func (s *statChecker) unmounted() bool {
+	if !s.hasDev {
+		return false
+	}
+	dfi, err := os.Stat(filepath.Dir(s.filename))
+	if err != nil {
+		return false
+	}
+	dev, ok := deviceID(dfi)
+	return ok && dev != s.dirDev
 }
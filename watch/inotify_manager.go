@@ -0,0 +1,281 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/masahide/fsnotify"
+)
+
+// subscriptionChanSize Subscription.Eventのバッファ
+const subscriptionChanSize = 100
+
+// InotifyManager inotifyインスタンスを全てのInotifyFileWatcherで共有する
+// 同じパスのwatchは参照カウントで管理し、イベントはパス毎の購読者に振り分ける
+//
+// インスタンスはディレクトリ監視用とファイル監視用の2つにしている。
+// fsnotify.FileEventはwatch descriptorを持たず、どちらのwatchも同じmaskのため、
+// 1つのインスタンスではディレクトリ監視のイベントとファイル監視のイベントが同じファイル名で届き区別できない。
+// ローテーションの検出(IsCreateの後の古いファイルのIsCloseWrite)にはこの区別が必要になる。
+// tailの数に関わらずプロセスで2つのため、max_user_instancesの上限には影響しない
+type InotifyManager struct {
+	mu    sync.Mutex
+	dirs  *inotifyInstance
+	files *inotifyInstance
+}
+
+// inotifyInstance 1つのinotifyインスタンスのwatchと購読者
+type inotifyInstance struct {
+	w      *fsnotify.Watcher
+	watch  func(path string) error // テスト用に差し替える
+	remove func(path string) error
+	dir    bool // ディレクトリ監視用
+
+	refs map[string]int
+	subs map[string]map[*Subscription]bool
+}
+
+// Subscription InotifyManagerに登録したパスのイベントの受信先
+type Subscription struct {
+	Event chan *fsnotify.FileEvent
+	Error chan error
+
+	m    *InotifyManager
+	in   *inotifyInstance
+	path string
+	done chan struct{}
+	once sync.Once
+
+	mu    sync.Mutex
+	queue []*fsnotify.FileEvent // Eventに送信していないイベント
+	wake  chan struct{}
+}
+
+func newInotifyManager(dirs, files *fsnotify.Watcher) *InotifyManager {
+	m := &InotifyManager{
+		dirs:  newInotifyInstance(dirs, true),
+		files: newInotifyInstance(files, false),
+	}
+	go m.dispatch(m.dirs)
+	go m.dispatch(m.files)
+	return m
+}
+
+func newInotifyInstance(w *fsnotify.Watcher, dir bool) *inotifyInstance {
+	return &inotifyInstance{
+		w:      w,
+		watch:  w.Watch,
+		remove: w.RemoveWatch,
+		dir:    dir,
+		refs:   map[string]int{},
+		subs:   map[string]map[*Subscription]bool{},
+	}
+}
+
+var defaultManager struct {
+	sync.Mutex
+	m *InotifyManager
+}
+
+// DefaultInotifyManager プロセスで共有するInotifyManager (inotifyインスタンスは2つ)
+// inotifyインスタンスを作成できない場合はエラー (次回の呼び出しで再度作成を試みる)
+func DefaultInotifyManager() (*InotifyManager, error) {
+	defaultManager.Lock()
+	defer defaultManager.Unlock()
+	if defaultManager.m != nil {
+		return defaultManager.m, nil
+	}
+	dirs, err := fsnotify.NewWatcher(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	files, err := fsnotify.NewWatcher(context.Background())
+	if err != nil {
+		dirs.Close(context.Background())
+		return nil, err
+	}
+	defaultManager.m = newInotifyManager(dirs, files)
+	return defaultManager.m, nil
+}
+
+// IsWatchLimit inotifyのインスタンス数(max_user_instances)やwatch数(max_user_watches)の上限によるエラー
+func IsWatchLimit(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EMFILE) {
+		return true
+	}
+	s := err.Error()
+	return strings.Contains(s, "no space left on device") || strings.Contains(s, "too many open files")
+}
+
+// WatchDir ディレクトリ内のファイルのイベントを購読する
+func (m *InotifyManager) WatchDir(dir string) (*Subscription, error) {
+	return m.subscribe(m.dirs, dir)
+}
+
+// WatchFile ファイルのイベントを購読する
+func (m *InotifyManager) WatchFile(file string) (*Subscription, error) {
+	return m.subscribe(m.files, file)
+}
+
+func (m *InotifyManager) subscribe(in *inotifyInstance, path string) (*Subscription, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if in.refs[abs] == 0 {
+		if err := in.watch(abs); err != nil {
+			return nil, fmt.Errorf("watch %s: %w", abs, err)
+		}
+	}
+	in.refs[abs]++
+	s := &Subscription{
+		Event: make(chan *fsnotify.FileEvent, subscriptionChanSize),
+		Error: make(chan error, 1),
+		m:     m,
+		in:    in,
+		path:  abs,
+		done:  make(chan struct{}),
+		wake:  make(chan struct{}, 1),
+	}
+	if in.subs[abs] == nil {
+		in.subs[abs] = map[*Subscription]bool{}
+	}
+	in.subs[abs][s] = true
+	go s.forward()
+	return s, nil
+}
+
+// Close 購読をやめる。最後の購読者の場合はwatchを削除する
+func (s *Subscription) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+		m, in := s.m, s.in
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(in.subs[s.path], s)
+		if len(in.subs[s.path]) == 0 {
+			delete(in.subs, s.path)
+		}
+		in.refs[s.path]--
+		if in.refs[s.path] > 0 {
+			return
+		}
+		delete(in.refs, s.path)
+		err = in.remove(s.path)
+	})
+	return err
+}
+
+// push イベントを送信待ちに加える。作成・削除・移動は取りこぼさず、連続するIN_MODIFYは1つにまとめる
+func (s *Subscription) push(evt *fsnotify.FileEvent) {
+	s.mu.Lock()
+	if n := len(s.queue); n == 0 || !evt.IsModify() || !s.queue[n-1].IsModify() {
+		s.queue = append(s.queue, evt)
+	}
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// forward 送信待ちのイベントを順にEventに送る。読み込まない購読者で他の購読者を止めない
+func (s *Subscription) forward() {
+	for {
+		select {
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+		for {
+			s.mu.Lock()
+			if len(s.queue) == 0 {
+				s.queue = nil
+				s.mu.Unlock()
+				break
+			}
+			evt := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+			select {
+			case s.Event <- evt:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+// Refs パス毎のwatchの参照数
+func (m *InotifyManager) Refs() map[string]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := map[string]int{}
+	for _, in := range []*inotifyInstance{m.dirs, m.files} {
+		for k, v := range in.refs {
+			res[k] += v
+		}
+	}
+	return res
+}
+
+// dispatch inのイベントを購読者に振り分ける。ディレクトリ監視は親ディレクトリ、ファイル監視はファイルの購読者
+func (m *InotifyManager) dispatch(in *inotifyInstance) {
+	for {
+		select {
+		case evt, ok := <-in.w.Event:
+			if !ok {
+				return
+			}
+			name, err := filepath.Abs(evt.Name)
+			if err != nil {
+				continue
+			}
+			if in.dir {
+				name = filepath.Dir(name)
+			}
+			for _, s := range m.subscribers(in, name) {
+				s.push(evt)
+			}
+		case err, ok := <-in.w.Error:
+			if !ok {
+				return
+			}
+			for _, s := range m.subscribers(in) {
+				select {
+				case s.Error <- err:
+				default: // 未読のエラーがある
+				}
+			}
+		}
+	}
+}
+
+// subscribers inのkeysの購読者。keysを指定しない場合は全ての購読者
+func (m *InotifyManager) subscribers(in *inotifyInstance, keys ...string) []*Subscription {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var res []*Subscription
+	if len(keys) == 0 {
+		for k := range in.subs {
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range keys {
+		for s := range in.subs[k] {
+			res = append(res, s)
+		}
+	}
+	return res
+}
@@ -0,0 +1,73 @@
+package watch
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/masahide/fsnotify"
+)
+
+func TestInotifyManager(t *testing.T) {
+	dw := &fsnotify.Watcher{Event: make(chan *fsnotify.FileEvent), Error: make(chan error)}
+	fw := &fsnotify.Watcher{Event: make(chan *fsnotify.FileEvent), Error: make(chan error)}
+	m := newInotifyManager(dw, fw)
+	var watched, removed []string
+	for _, in := range []*inotifyInstance{m.dirs, m.files} {
+		in.watch = func(p string) error { watched = append(watched, p); return nil }
+		in.remove = func(p string) error { removed = append(removed, p); return nil }
+	}
+
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log")
+	da, _ := m.WatchDir(dir)
+	db, _ := m.WatchDir(dir)
+	fa, _ := m.WatchFile(a)
+	if len(watched) != 2 || m.Refs()[dir] != 2 {
+		t.Fatalf("watched=%v refs=%v", watched, m.Refs())
+	}
+
+	dw.Event <- &fsnotify.FileEvent{Name: a}
+	dw.Event <- &fsnotify.FileEvent{Name: b}
+	fw.Event <- &fsnotify.FileEvent{Name: a}
+	expect := map[*Subscription][]string{da: {a, b}, db: {a, b}, fa: {a}}
+	for s, names := range expect {
+		for _, name := range names {
+			select {
+			case evt := <-s.Event:
+				if evt.Name != name {
+					t.Errorf("evt.Name=%s, want %s", evt.Name, name)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s: timeout", name)
+			}
+		}
+		if len(s.Event) != 0 {
+			t.Errorf("%s: unexpected events %d", s.path, len(s.Event))
+		}
+	}
+
+	// 読み込んでいない購読者のイベントもバッファを超えて取りこぼさない
+	n := subscriptionChanSize * 2
+	for i := 0; i < n; i++ {
+		fw.Event <- &fsnotify.FileEvent{Name: a}
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case <-fa.Event:
+		case <-time.After(time.Second):
+			t.Fatalf("received %d events, want %d", i, n)
+		}
+	}
+
+	da.Close()
+	da.Close()
+	fa.Close()
+	if len(removed) != 1 || removed[0] != a {
+		t.Fatalf("removed=%v", removed)
+	}
+	db.Close()
+	if len(removed) != 2 || len(m.Refs()) != 0 {
+		t.Fatalf("removed=%v refs=%v", removed, m.Refs())
+	}
+}
@@ -0,0 +1,42 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStatChecker(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "a.log")
+	write := func(flag int, s string) {
+		f, err := os.OpenFile(name, flag|os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(s)
+		f.Close()
+	}
+	write(os.O_TRUNC, "a\n")
+	fi, _ := os.Stat(name)
+	st := newStatChecker(name, fi)
+
+	steps := []struct {
+		fn   func()
+		want EventKind
+	}{
+		{func() {}, None},
+		{func() { write(os.O_APPEND, "bb\n") }, Modified},
+		{func() { os.Chmod(name, 0600) }, PermissionChanged},
+		{func() { write(os.O_TRUNC, "") }, Truncated},
+		{func() { os.Rename(name, name+".1"); write(os.O_TRUNC, "d\n") }, RotatedByCreate},
+		{func() { os.Remove(name) }, Deleted},
+	}
+	for i, s := range steps {
+		s.fn()
+		if ev := st.check(); ev.Kind != s.want {
+			t.Errorf("%d: check=%s, want %s", i, ev.Kind, s.want)
+		} else if ev.Kind == Modified && (ev.OldSize != 2 || ev.NewSize != 5) {
+			t.Errorf("%d: size=%d->%d, want 2->5", i, ev.OldSize, ev.NewSize)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package watch
 
 import (
+	"cmp"
 	"context"
-	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -11,16 +13,74 @@ import (
 type PollingFileWatcher struct {
 	Filename string
 	Size     int64
+	Logger   *slog.Logger
+	// Interval is the polling interval after a change. 0 means DefaultPollInterval.
+	Interval time.Duration
+	// MaxInterval is the cap of the interval, which doubles while the file is idle.
+	// 0 means DefaultMaxPollInterval. Set it to Interval to poll at a fixed rate.
+	MaxInterval time.Duration
+	// Symlink reports Retargeted when Filename, a symlink, points to another file.
+	Symlink bool
+	// Delay is the grace period before reporting Deleted. A file recreated within it is a rotation.
+	Delay time.Duration
 }
 
+const (
+	DefaultPollInterval    = 250 * time.Millisecond
+	DefaultMaxPollInterval = 2 * time.Second
+)
+
 func NewPollingFileWatcher(filename string) *PollingFileWatcher {
 	fw := &PollingFileWatcher{Filename: filename, Size: 0}
 	return fw
 }
 
-var POLL_DURATION time.Duration
+// backoff polling interval which grows while nothing changes.
+type backoff struct {
+	min, max, cur time.Duration
+}
+
+func (fw *PollingFileWatcher) backoff() *backoff {
+	b := &backoff{min: fw.Interval, max: fw.MaxInterval}
+	if b.min <= 0 {
+		b.min = DefaultPollInterval
+	}
+	if b.max <= 0 {
+		b.max = DefaultMaxPollInterval
+	}
+	if b.max < b.min {
+		b.max = b.min
+	}
+	b.cur = b.min
+	return b
+}
+
+// next returns the interval until the next poll. active resets it to the minimum.
+func (b *backoff) next(active bool) time.Duration {
+	if active {
+		b.cur = b.min
+		return b.cur
+	}
+	if b.cur *= 2; b.cur > b.max {
+		b.cur = b.max
+	}
+	return b.cur
+}
+
+// target Symlinkの場合はリンク先のパス
+func (fw *PollingFileWatcher) target() string {
+	if !fw.Symlink {
+		return fw.Filename
+	}
+	target, err := filepath.EvalSymlinks(fw.Filename)
+	if err != nil {
+		return fw.Filename
+	}
+	return target
+}
 
 func (fw *PollingFileWatcher) BlockUntilExists(ctx context.Context) error {
+	b := fw.backoff()
 	for {
 		if _, err := os.Stat(fw.Filename); err == nil {
 			return nil
@@ -28,8 +88,8 @@ func (fw *PollingFileWatcher) BlockUntilExists(ctx context.Context) error {
 			return err
 		}
 		select {
-		case <-time.After(POLL_DURATION):
-			continue
+		case <-time.After(b.cur):
+			b.next(false)
 		case <-ctx.Done():
 			return ctx.Err()
 		}
@@ -52,27 +112,37 @@ func (fw *PollingFileWatcher) ChangeEvents(ctx context.Context, origFi os.FileIn
 	// the fatal (below) with tomb's Kill.
 
 	fw.Size = origFi.Size()
+	target := fw.target()
 
 	go func() {
 		defer changes.Close()
 
 		var retry int = 0
 
-		prevSize := fw.Size
+		b := fw.backoff()
+		interval := b.min
+		prev := origFi
+		var missing time.Time // ファイルが見つからなくなった時刻
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			default:
+			case <-time.After(interval):
 			}
+			interval = b.next(false)
 
-			time.Sleep(POLL_DURATION)
 			fi, err := os.Stat(fw.Filename)
 			if err != nil {
 				if os.IsNotExist(err) {
-					// File does not exist (has been deleted).
-					changes.NotifyRotated(ctx)
-					return
+					// File does not exist (has been deleted). Wait for the recreation until Delay.
+					if missing.IsZero() {
+						missing = time.Now()
+					}
+					if time.Since(missing) >= fw.Delay {
+						changes.Notify(ctx, newEvent(Deleted, prev, nil))
+						return
+					}
+					continue
 				}
 
 				if permissionErrorRetry(err, &retry) {
@@ -80,36 +150,42 @@ func (fw *PollingFileWatcher) ChangeEvents(ctx context.Context, origFi os.FileIn
 				}
 
 				// XXX: report this error back to the user
-				log.Printf("Failed to stat file %v: %v", fw.Filename, err)
+				cmp.Or(fw.Logger, slog.Default()).Warn("failed to stat file", "file", fw.Filename, "err", err)
+				continue
 			}
+			missing = time.Time{}
 
 			// File got moved/renamed?
 			if !os.SameFile(origFi, fi) {
-				changes.NotifyRotated(ctx)
+				kind := RotatedByCreate
+				if fw.Symlink && fw.target() != target {
+					kind = Retargeted
+				}
+				changes.Notify(ctx, newEvent(kind, prev, fi))
 				return
 			}
 
 			// File got truncated?
 			fw.Size = fi.Size()
-			if prevSize > 0 && prevSize > fw.Size {
-				changes.NotifyRotated(ctx)
-				prevSize = fw.Size
-				continue
+			if prev.Size() > 0 && prev.Size() > fw.Size {
+				changes.Notify(ctx, newEvent(Truncated, prev, fi))
+				return
+			}
+
+			if fi.Mode() != prev.Mode() {
+				changes.Notify(ctx, newEvent(PermissionChanged, prev, fi))
 			}
-			prevSize = fw.Size
 
 			// File was appended to (changed)?
 			modTime := fi.ModTime()
 			if modTime != prevModTime {
 				prevModTime = modTime
-				changes.NotifyModified(ctx)
+				interval = b.next(true)
+				changes.Notify(ctx, newEvent(Modified, prev, fi))
 			}
+			prev = fi
 		}
 	}()
 
 	return changes
 }
-
-func init() {
-	POLL_DURATION = 250 * time.Millisecond
-}
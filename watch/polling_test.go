@@ -0,0 +1,58 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := (&PollingFileWatcher{Interval: 100, MaxInterval: 500}).backoff()
+	var res []time.Duration
+	for _, active := range []bool{false, false, false, false, true, false} {
+		res = append(res, b.next(active))
+	}
+	expect := []time.Duration{200, 400, 500, 500, 100, 200}
+	if !reflect.DeepEqual(res, expect) {
+		t.Errorf("next=%v, want %v", res, expect)
+	}
+	if b := (&PollingFileWatcher{}).backoff(); b.min != DefaultPollInterval || b.max != DefaultMaxPollInterval {
+		t.Errorf("default backoff=%+v", b)
+	}
+}
+
+func TestPollingDeleteDelay(t *testing.T) {
+	for _, recreate := range []bool{false, true} {
+		name := filepath.Join(t.TempDir(), "a.log")
+		os.WriteFile(name, []byte("a\n"), 0644)
+		fi, _ := os.Stat(name)
+		fw := &PollingFileWatcher{Filename: name, Interval: 10 * time.Millisecond, MaxInterval: 10 * time.Millisecond, Delay: 200 * time.Millisecond}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		changes := fw.ChangeEvents(ctx, fi)
+		f, _ := os.Open(name) // inodeを再利用させない
+		os.Remove(name)
+		if recreate { // delayの間に再作成されたらrotate
+			time.Sleep(50 * time.Millisecond)
+			os.WriteFile(name, []byte("b\n"), 0644)
+		}
+		want := Deleted
+		if recreate {
+			want = RotatedByCreate
+		}
+		got := None
+		for ev := range changes.Events {
+			if ev.Kind != Modified {
+				got = ev.Kind
+				break
+			}
+		}
+		if got != want {
+			t.Errorf("recreate=%v: got %s, want %s", recreate, got, want)
+		}
+		f.Close()
+		cancel()
+	}
+}